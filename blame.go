@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   gud blame: who last changed each line
+-------------------------------------------*/
+
+// blameLine ties a line of the blamed file (final) to its number in the
+// version of the file being examined (orig).
+type blameLine struct {
+	final, orig int
+}
+
+// blameSuspect is a set of lines that a commit, seen under path, may have
+// introduced.
+type blameSuspect struct {
+	id, path string
+	lines    []blameLine
+}
+
+// blameEntry is the verdict for one line of the blamed file.
+type blameEntry struct {
+	id, path string
+	orig     int
+}
+
+func blameUsage() {
+	fmt.Println("Usage: gud blame [-L <start>,<end>] [--porcelain] [<revision>] [--] <path>")
+}
+
+func blameCommand(args []string) {
+	porcelain := false
+	rangeArg := ""
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--porcelain" || a == "-p":
+			porcelain = true
+		case a == "-L" && i+1 < len(args):
+			i++
+			rangeArg = args[i]
+		case strings.HasPrefix(a, "-L"):
+			rangeArg = a[2:]
+		case a == "--":
+			positional = append(positional, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "-"):
+			fmt.Println("Error: unknown option:", a)
+			blameUsage()
+			return
+		default:
+			positional = append(positional, a)
+		}
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		blameUsage()
+		return
+	}
+	path := strings.TrimPrefix(positional[len(positional)-1], "./")
+
+	// Without a revision the working copy is blamed, with uncommitted lines
+	// attributed to ZERO_ID.
+	var content string
+	var start string
+	if len(positional) == 2 {
+		id, err := resolveRevision(positional[0])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		c, ok := commitFiles(id)[path]
+		if !ok {
+			fmt.Printf("Error: no such path %s in %s\n", path, positional[0])
+			return
+		}
+		content, start = c, id
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println("Error: no such path", path)
+			return
+		}
+		content, start = string(data), ZERO_ID
+	}
+
+	lines := splitLines(content)
+	from, to, err := blameRange(rangeArg, len(lines))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	entries := blame(start, path, lines)
+	if porcelain {
+		printBlamePorcelain(entries, lines, from, to)
+	} else {
+		printBlame(entries, lines, path, from, to)
+	}
+}
+
+// blameRange parses -L "<start>,<end>" or "<start>,+<count>" (1-based,
+// inclusive) into a half-open range of line indexes.
+func blameRange(arg string, n int) (int, int, error) {
+	if arg == "" {
+		return 0, n, nil
+	}
+	parts := strings.SplitN(arg, ",", 2)
+	start, end := 1, n
+	var err error
+	if parts[0] != "" {
+		if start, err = strconv.Atoi(parts[0]); err != nil || start < 1 {
+			return 0, 0, fmt.Errorf("invalid -L start: %s", parts[0])
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		if strings.HasPrefix(parts[1], "+") {
+			count, err := strconv.Atoi(parts[1][1:])
+			if err != nil || count < 1 {
+				return 0, 0, fmt.Errorf("invalid -L count: %s", parts[1])
+			}
+			end = start + count - 1
+		} else if end, err = strconv.Atoi(parts[1]); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid -L end: %s", parts[1])
+		}
+	}
+	if start > n {
+		return 0, 0, fmt.Errorf("file has only %d lines", n)
+	}
+	if end > n {
+		end = n
+	}
+	return start - 1, end, nil
+}
+
+// blame attributes each line to the commit that introduced it. Starting
+// from start (or the working copy for ZERO_ID), lines that a commit shares
+// with a parent are passed on to that parent; whatever no parent has is
+// the commit's own. Commits are examined newest first, so lines arriving
+// along several paths of a merge are handled together.
+func blame(start, path string, lines []string) []blameEntry {
+	entries := make([]blameEntry, len(lines))
+	initial := blameSuspect{id: start, path: path}
+	for i := range lines {
+		initial.lines = append(initial.lines, blameLine{i, i})
+	}
+	pending := map[string]*blameSuspect{start + "\x00" + path: &initial}
+
+	for len(pending) > 0 {
+		key := newestSuspect(pending)
+		s := pending[key]
+		delete(pending, key)
+
+		var content string
+		var parents []string
+		var child map[string]string
+		if s.id == ZERO_ID {
+			content, child = strings.Join(lines, ""), getWorkingFiles()
+			if head := currentBranchHead(); head != "" {
+				parents = []string{head}
+			}
+		} else {
+			c, err := readCommit(s.id)
+			if err != nil {
+				continue
+			}
+			content, parents, child = c.Files[s.path], c.Parents, c.Files
+		}
+		current := splitLines(content)
+
+		remaining := s.lines
+		for _, p := range parents {
+			if len(remaining) == 0 {
+				break
+			}
+			parentFiles := commitFiles(p)
+			parentPath, ok := renameSource(parentFiles, child, s.path, content)
+			if !ok {
+				continue
+			}
+			match := lineMatches(current, splitLines(parentFiles[parentPath]))
+			var passed, kept []blameLine
+			for _, l := range remaining {
+				if m := match[l.orig]; m >= 0 {
+					passed = append(passed, blameLine{l.final, m})
+				} else {
+					kept = append(kept, l)
+				}
+			}
+			if len(passed) > 0 {
+				k := p + "\x00" + parentPath
+				if pending[k] == nil {
+					pending[k] = &blameSuspect{id: p, path: parentPath}
+				}
+				pending[k].lines = append(pending[k].lines, passed...)
+			}
+			remaining = kept
+		}
+		for _, l := range remaining {
+			entries[l.final] = blameEntry{s.id, s.path, l.orig}
+		}
+	}
+	return entries
+}
+
+// newestSuspect picks the pending suspect whose commit is the most recent.
+// The working copy comes before every commit.
+func newestSuspect(pending map[string]*blameSuspect) string {
+	best := ""
+	var bestTime time.Time
+	for key, s := range pending {
+		if s.id == ZERO_ID {
+			return key
+		}
+		c, err := readCommit(s.id)
+		if err != nil {
+			return key
+		}
+		t := commitTime(c)
+		if best == "" || t.After(bestTime) || (t.Equal(bestTime) && key > best) {
+			best, bestTime = key, t
+		}
+	}
+	return best
+}
+
+// blameAuthor returns who to credit for a commit; the working copy is
+// credited to nobody yet.
+func blameAuthor(id string) (*Commit, string) {
+	if id == ZERO_ID {
+		return nil, "Not Committed Yet"
+	}
+	c, err := readCommit(id)
+	if err != nil {
+		return nil, "unknown"
+	}
+	name, _ := splitIdentity(c.Author)
+	return c, name
+}
+
+// printBlame prints "<id> [<path>] (<author> <date> <line>) <text>". The
+// path column only appears if some lines came from the file under another
+// name.
+func printBlame(entries []blameEntry, lines []string, path string, from, to int) {
+	showPaths := false
+	width, nameWidth, pathWidth := len(strconv.Itoa(to)), 0, 0
+	for _, e := range entries[from:to] {
+		_, name := blameAuthor(e.id)
+		nameWidth = max(nameWidth, len(name))
+		pathWidth = max(pathWidth, len(e.path))
+		if e.path != path {
+			showPaths = true
+		}
+	}
+	for i := from; i < to; i++ {
+		e := entries[i]
+		c, name := blameAuthor(e.id)
+		date := time.Now().Format("2006-01-02 15:04:05 -0700")
+		if c != nil {
+			date = commitTime(c).Format("2006-01-02 15:04:05 -0700")
+		}
+		pathCol := ""
+		if showPaths {
+			pathCol = fmt.Sprintf("%-*s ", pathWidth, e.path)
+		}
+		fmt.Printf("%s %s(%-*s %s %*d) %s\n", shortID(e.id), pathCol, nameWidth, name, date, width, i+1, strings.TrimSuffix(lines[i], "\n"))
+	}
+}
+
+// printBlamePorcelain prints the machine-readable format: a header line
+// "<id> <orig line> <final line> [<lines in group>]" for each line, the
+// commit's details the first time it appears, and the line itself after a
+// tab.
+func printBlamePorcelain(entries []blameEntry, lines []string, from, to int) {
+	seen := make(map[string]bool)
+	for i := from; i < to; i++ {
+		e := entries[i]
+		header := fmt.Sprintf("%s %d %d", e.id, e.orig+1, i+1)
+		if i == from || entries[i-1].id != e.id || entries[i-1].orig+1 != e.orig {
+			n := 1
+			for j := i + 1; j < to && entries[j].id == e.id && entries[j].orig == entries[j-1].orig+1; j++ {
+				n++
+			}
+			header += " " + strconv.Itoa(n)
+		}
+		fmt.Println(header)
+		if !seen[e.id] {
+			seen[e.id] = true
+			for _, l := range blameCommitHeaders(e.id) {
+				fmt.Println(l)
+			}
+		}
+		fmt.Println("filename " + e.path)
+		fmt.Println("\t" + strings.TrimSuffix(lines[i], "\n"))
+	}
+}
+
+func blameCommitHeaders(id string) []string {
+	c, name := blameAuthor(id)
+	if c == nil {
+		now := time.Now()
+		return []string{
+			"author " + name, "author-mail <not.committed.yet>",
+			"author-time " + strconv.FormatInt(now.Unix(), 10), "author-tz " + now.Format("-0700"),
+			"committer " + name, "committer-mail <not.committed.yet>",
+			"committer-time " + strconv.FormatInt(now.Unix(), 10), "committer-tz " + now.Format("-0700"),
+			"summary Version of the working copy",
+		}
+	}
+	t := commitTime(c)
+	_, mail := splitIdentity(c.Author)
+	cname, cmail := splitIdentity(committerOf(c))
+	headers := []string{
+		"author " + name, "author-mail <" + mail + ">",
+		"author-time " + strconv.FormatInt(t.Unix(), 10), "author-tz " + t.Format("-0700"),
+		"committer " + cname, "committer-mail <" + cmail + ">",
+		"committer-time " + strconv.FormatInt(t.Unix(), 10), "committer-tz " + t.Format("-0700"),
+		"summary " + subjectLine(c.Message),
+	}
+	if len(c.Parents) == 0 {
+		headers = append(headers, "boundary")
+	}
+	return headers
+}
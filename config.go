@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ----------------------------------------
+   Hierarchical configuration (system, global, local)
+-------------------------------------------*/
+
+const (
+	SYSTEM_CONFIG_FILE = "/etc/gudconfig"
+	GLOBAL_CONFIG_FILE = ".gudconfig" // relative to the user's home directory
+)
+
+type configScope struct {
+	Name string
+	Path string
+}
+
+// configScopes returns the config files in increasing order of precedence.
+// GUD_CONFIG_SYSTEM and GUD_CONFIG_GLOBAL override the default locations.
+func configScopes() []configScope {
+	system := os.Getenv("GUD_CONFIG_SYSTEM")
+	if system == "" {
+		system = SYSTEM_CONFIG_FILE
+	}
+	global := os.Getenv("GUD_CONFIG_GLOBAL")
+	if global == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			global = filepath.Join(home, GLOBAL_CONFIG_FILE)
+		}
+	}
+	return []configScope{
+		{"system", system},
+		{"global", global},
+		{"local", CONFIG_FILE},
+	}
+}
+
+// CONFIG_SECTIONS are the sections gud itself reads.
+var CONFIG_SECTIONS = []string{"branch", "commit", "core", "diff", "gc", "gpg", "merge", "pull", "remote", "tag", "user"}
+
+const LEGACY_CONFIG_WARNING = "warning: 'gud config <username> <email>' is deprecated; use 'gud config user.name <username>' and 'gud config user.email <email>'."
+
+func knownConfigSection(section string) bool {
+	for _, s := range CONFIG_SECTIONS {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+func configScopeByName(name string) (configScope, bool) {
+	for _, s := range configScopes() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return configScope{}, false
+}
+
+// normalizeConfigKey lower-cases the section and variable name of a dotted
+// key while keeping any subsection (e.g. a remote name) as typed.
+func normalizeConfigKey(key string) (string, bool) {
+	parts := strings.Split(key, ".")
+	if len(parts) < 2 {
+		return "", false
+	}
+	for _, p := range parts {
+		if p == "" {
+			return "", false
+		}
+	}
+	parts[0] = strings.ToLower(parts[0])
+	parts[len(parts)-1] = strings.ToLower(parts[len(parts)-1])
+	return strings.Join(parts, "."), true
+}
+
+func loadConfigFile(path string) map[string]string {
+	cfg := make(map[string]string)
+	if path == "" {
+		return cfg
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: ignoring malformed config file:", path)
+		return cfg
+	}
+	for k, v := range raw {
+		// Repositories created before dotted keys stored {"username", "email"}.
+		switch k {
+		case "username":
+			k = "user.name"
+		case "email":
+			k = "user.email"
+		}
+		if nk, ok := normalizeConfigKey(k); ok {
+			cfg[nk] = v
+		}
+	}
+	return cfg
+}
+
+func saveConfigFile(path string, cfg map[string]string) error {
+	if dir := filepath.Dir(path); dir != "" {
+		os.MkdirAll(dir, 0755)
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// configValueOrigin looks a key up in every scope and returns the value from
+// the most specific one, together with the file it came from.
+func configValueOrigin(key string) (string, string, bool) {
+	key, ok := normalizeConfigKey(key)
+	if !ok {
+		return "", "", false
+	}
+	var value, origin string
+	found := false
+	for _, s := range configScopes() {
+		if v, ok := loadConfigFile(s.Path)[key]; ok {
+			value, origin, found = v, s.Path, true
+		}
+	}
+	return value, origin, found
+}
+
+func configValue(key string) (string, bool) {
+	v, _, ok := configValueOrigin(key)
+	return v, ok
+}
+
+func configString(key, def string) string {
+	if v, ok := configValue(key); ok {
+		return v
+	}
+	return def
+}
+
+func configBool(key string, def bool) bool {
+	v, ok := configValue(key)
+	if !ok {
+		return def
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true", "yes", "on", "1", "":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	}
+	return def
+}
+
+func setConfigValue(scope configScope, key, value string) error {
+	nk, ok := normalizeConfigKey(key)
+	if !ok {
+		return fmt.Errorf("invalid key: %s", key)
+	}
+	if scope.Name == "local" {
+		if _, err := os.Stat(GUD_DIR); err != nil {
+			return fmt.Errorf("not a gud repository")
+		}
+	}
+	cfg := loadConfigFile(scope.Path)
+	cfg[nk] = value
+	return saveConfigFile(scope.Path, cfg)
+}
+
+func unsetConfigValue(scope configScope, key string) (bool, error) {
+	nk, ok := normalizeConfigKey(key)
+	if !ok {
+		return false, fmt.Errorf("invalid key: %s", key)
+	}
+	cfg := loadConfigFile(scope.Path)
+	if _, ok := cfg[nk]; !ok {
+		return false, nil
+	}
+	delete(cfg, nk)
+	return true, saveConfigFile(scope.Path, cfg)
+}
+
+// configSubsection splits a key of section into its subsection and
+// variable name: "remote.a.b.url" is subsection "a.b", variable "url".
+func configSubsection(key, section string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(key, section+".")
+	i := strings.LastIndexByte(rest, '.')
+	if !ok || i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// unsetConfigSection removes every key of one subsection (e.g. remote
+// "origin"), leaving subsections whose names merely start the same.
+func unsetConfigSection(scope configScope, section, sub string) error {
+	cfg := loadConfigFile(scope.Path)
+	for k := range cfg {
+		if s, _, ok := configSubsection(k, section); ok && s == sub {
+			delete(cfg, k)
+		}
+	}
+	return saveConfigFile(scope.Path, cfg)
+}
+
+// userIdentity formats user.name and user.email as "Name <email>".
+func userIdentity() string {
+	name := configString("user.name", "")
+	email := configString("user.email", "")
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+func handleConfigCommand(args []string) {
+	usage := "Usage: gud config [--system|--global|--local] [--show-origin] (--list | --get <key> | --unset <key> | <key> [<value>])"
+	var scopeName, action string
+	showOrigin := false
+	var rest []string
+	for _, a := range args {
+		switch a {
+		case "--system", "--global", "--local":
+			scopeName = strings.TrimPrefix(a, "--")
+		case "--show-origin":
+			showOrigin = true
+		case "--list", "-l":
+			action = "list"
+		case "--get":
+			action = "get"
+		case "--unset":
+			action = "unset"
+		default:
+			rest = append(rest, a)
+		}
+	}
+
+	if action == "" {
+		switch len(rest) {
+		case 1:
+			action = "get"
+		case 2:
+			action = "set"
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	// The original form "gud config <username> <email>" keeps working, in
+	// whichever scope was asked for, but is deprecated. A dotted user name
+	// looks like a key, so an email-like value for a section gud does not
+	// know is refused rather than stored under a made-up key.
+	if action == "set" {
+		if _, ok := normalizeConfigKey(rest[0]); !ok {
+			if scopeName == "" {
+				scopeName = "local"
+			}
+			scope, _ := configScopeByName(scopeName)
+			if err := setConfigValue(scope, "user.name", rest[0]); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+			if err := setConfigValue(scope, "user.email", rest[1]); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+			fmt.Println("User config saved.")
+			fmt.Println(LEGACY_CONFIG_WARNING)
+			return
+		}
+		section := strings.ToLower(strings.SplitN(rest[0], ".", 2)[0])
+		if strings.Contains(rest[1], "@") && !knownConfigSection(section) {
+			fmt.Printf("Error: unknown config section %q; to set your name and email use\n", section)
+			fmt.Println("  gud config user.name <username>")
+			fmt.Println("  gud config user.email <email>")
+			return
+		}
+	}
+
+	switch action {
+	case "list":
+		listConfig(scopeName, showOrigin)
+	case "get":
+		if len(rest) != 1 {
+			fmt.Println(usage)
+			return
+		}
+		getConfig(scopeName, rest[0], showOrigin)
+	case "set":
+		if len(rest) != 2 {
+			fmt.Println(usage)
+			return
+		}
+		if scopeName == "" {
+			scopeName = "local"
+		}
+		scope, _ := configScopeByName(scopeName)
+		if err := setConfigValue(scope, rest[0], rest[1]); err != nil {
+			fmt.Println("Error saving config:", err)
+		}
+	case "unset":
+		if len(rest) != 1 {
+			fmt.Println(usage)
+			return
+		}
+		if scopeName == "" {
+			scopeName = "local"
+		}
+		scope, _ := configScopeByName(scopeName)
+		removed, err := unsetConfigValue(scope, rest[0])
+		if err != nil {
+			fmt.Println("Error saving config:", err)
+		} else if !removed {
+			fmt.Println("Key not set:", rest[0])
+		}
+	}
+}
+
+func getConfig(scopeName, key string, showOrigin bool) {
+	var value, origin string
+	found := false
+	if scopeName != "" {
+		scope, _ := configScopeByName(scopeName)
+		nk, ok := normalizeConfigKey(key)
+		if ok {
+			value, found = loadConfigFile(scope.Path)[nk]
+			origin = scope.Path
+		}
+	} else {
+		value, origin, found = configValueOrigin(key)
+	}
+	if !found {
+		os.Exit(1)
+	}
+	if showOrigin {
+		fmt.Printf("file:%s\t%s\n", origin, value)
+	} else {
+		fmt.Println(value)
+	}
+}
+
+func listConfig(scopeName string, showOrigin bool) {
+	for _, s := range configScopes() {
+		if scopeName != "" && s.Name != scopeName {
+			continue
+		}
+		cfg := loadConfigFile(s.Path)
+		keys := make([]string, 0, len(cfg))
+		for k := range cfg {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if showOrigin {
+				fmt.Printf("file:%s\t%s=%s\n", s.Path, k, cfg[k])
+			} else {
+				fmt.Printf("%s=%s\n", k, cfg[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+/* ----------------------------------------
+   Unified diffs
+-------------------------------------------*/
+
+const DIFF_CONTEXT = 3
+
+// BINARY_CHECK_SIZE is how much of a file is searched for a NUL byte to
+// decide that it is binary, as git does.
+const BINARY_CHECK_SIZE = 8000
+
+// FILE_MODE is the mode patches give every file; gud does not track modes.
+const FILE_MODE = "100644"
+
+const (
+	COLOR_RED   = "\x1b[31m"
+	COLOR_GREEN = "\x1b[32m"
+	COLOR_RESET = "\x1b[m"
+)
+
+// diffOptions controls how changes are found and shown: rename detection,
+// which whitespace changes to ignore, and whether changed lines are shown
+// whole or word by word ("plain" or "color").
+type diffOptions struct {
+	detect            renameDetection
+	ignoreAllSpace    bool
+	ignoreSpaceChange bool
+	ignoreBlankLines  bool
+	wordDiff          string
+}
+
+func defaultDiffOptions() diffOptions {
+	return diffOptions{detect: defaultRenameDetection()}
+}
+
+// diffFlags removes the options shared by diff, show and log -p from args:
+// rename detection, -w/--ignore-all-space, -b/--ignore-space-change,
+// --ignore-blank-lines, --word-diff[=plain|color|none] and --color-words.
+func diffFlags(args []string, opts diffOptions) ([]string, diffOptions, error) {
+	args, detect, err := renameFlags(args, opts.detect)
+	if err != nil {
+		return nil, opts, err
+	}
+	opts.detect = detect
+	var rest []string
+	for _, a := range args {
+		switch a {
+		case "-w", "--ignore-all-space":
+			opts.ignoreAllSpace = true
+		case "-b", "--ignore-space-change":
+			opts.ignoreSpaceChange = true
+		case "--ignore-blank-lines":
+			opts.ignoreBlankLines = true
+		case "--word-diff", "--word-diff=plain":
+			opts.wordDiff = "plain"
+		case "--word-diff=color", "--color-words":
+			opts.wordDiff = "color"
+		case "--word-diff=none":
+			opts.wordDiff = ""
+		default:
+			if strings.HasPrefix(a, "--word-diff=") {
+				return nil, opts, fmt.Errorf("invalid --word-diff mode: %s", strings.TrimPrefix(a, "--word-diff="))
+			}
+			rest = append(rest, a)
+		}
+	}
+	return rest, opts, nil
+}
+
+// lineKey is what a line is compared by once ignored whitespace is removed.
+func (o diffOptions) lineKey(line string) string {
+	switch {
+	case o.ignoreAllSpace:
+		return strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, line)
+	case o.ignoreSpaceChange:
+		return strings.Join(strings.Fields(line), " ")
+	}
+	return line
+}
+
+// lineOps diffs two texts line by line under the whitespace options.
+func (o diffOptions) lineOps(a, b []string) []diffOp {
+	if !o.ignoreAllSpace && !o.ignoreSpaceChange {
+		return diffLines(a, b)
+	}
+	ka, kb := make([]string, len(a)), make([]string, len(b))
+	for i, l := range a {
+		ka[i] = o.lineKey(l)
+	}
+	for i, l := range b {
+		kb[i] = o.lineKey(l)
+	}
+	return diffLines(ka, kb)
+}
+
+// significant reports whether an op is a change that should be shown.
+// With --ignore-blank-lines, adding or removing blank lines is not.
+func (o diffOptions) significant(op diffOp, a, b []string) bool {
+	switch op.Kind {
+	case '-':
+		return !o.ignoreBlankLines || strings.TrimSpace(a[op.A]) != ""
+	case '+':
+		return !o.ignoreBlankLines || strings.TrimSpace(b[op.B]) != ""
+	}
+	return false
+}
+
+// lineCounts returns how many lines were added and deleted, for --stat and
+// --numstat.
+func (o diffOptions) lineCounts(old, new string) (int, int) {
+	a, b := splitLines(old), splitLines(new)
+	added, deleted := 0, 0
+	for _, op := range o.lineOps(a, b) {
+		if !o.significant(op, a, b) {
+			continue
+		}
+		if op.Kind == '+' {
+			added++
+		} else {
+			deleted++
+		}
+	}
+	return added, deleted
+}
+
+// filePatch renders the change to one file as a unified diff. hasOld and
+// hasNew say whether the file exists before and after, so additions and
+// deletions are shown against /dev/null. A modification that only touches
+// ignored whitespace renders as nothing.
+func filePatch(path, old, new string, hasOld, hasNew bool, opts diffOptions) string {
+	if hasOld == hasNew && old == new {
+		return ""
+	}
+	binary := isBinary(old) || isBinary(new)
+	hunks := ""
+	if !binary {
+		hunks = unifiedHunks(splitLines(old), splitLines(new), DIFF_CONTEXT, opts)
+		if hasOld && hasNew && hunks == "" {
+			return ""
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", path, path)
+	from, to := "a/"+path, "b/"+path
+	oldBlob, newBlob := shortBlobID(old, hasOld), shortBlobID(new, hasNew)
+	if !hasOld {
+		b.WriteString("new file mode " + FILE_MODE + "\n")
+		from = "/dev/null"
+	}
+	if !hasNew {
+		b.WriteString("deleted file mode " + FILE_MODE + "\n")
+		to = "/dev/null"
+	}
+	if hasOld && hasNew {
+		fmt.Fprintf(&b, "index %s..%s %s\n", oldBlob, newBlob, FILE_MODE)
+	} else {
+		fmt.Fprintf(&b, "index %s..%s\n", oldBlob, newBlob)
+	}
+	if binary {
+		fmt.Fprintf(&b, "Binary files %s and %s differ\n", from, to)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+	b.WriteString(hunks)
+	return b.String()
+}
+
+// treePatch renders every difference between two snapshots, in path order,
+// pairing up renamed and copied files as the options allow.
+func treePatch(a, b map[string]string, opts diffOptions) string {
+	var out strings.Builder
+	for _, ch := range opts.detect.changes(a, b) {
+		old, hasOld := a[ch.Old]
+		new, hasNew := b[ch.New]
+		if ch.Status == 'R' || ch.Status == 'C' {
+			out.WriteString(renamePatch(ch, old, new, opts))
+			continue
+		}
+		out.WriteString(filePatch(changePath(ch), old, new, hasOld, hasNew, opts))
+	}
+	return out.String()
+}
+
+// renamePatch renders a renamed or copied file: its similarity, the old and
+// new names and any changes to its content.
+func renamePatch(ch fileChange, old, new string, opts diffOptions) string {
+	var b strings.Builder
+	kind := "rename"
+	if ch.Status == 'C' {
+		kind = "copy"
+	}
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\nsimilarity index %d%%\n", ch.Old, ch.New, ch.Score)
+	fmt.Fprintf(&b, "%s from %s\n%s to %s\n", kind, ch.Old, kind, ch.New)
+	if old != new && (isBinary(old) || isBinary(new)) {
+		fmt.Fprintf(&b, "index %s..%s %s\n", shortBlobID(old, true), shortBlobID(new, true), FILE_MODE)
+		fmt.Fprintf(&b, "Binary files a/%s and b/%s differ\n", ch.Old, ch.New)
+	} else if hunks := unifiedHunks(splitLines(old), splitLines(new), DIFF_CONTEXT, opts); hunks != "" {
+		fmt.Fprintf(&b, "index %s..%s %s\n", shortBlobID(old, true), shortBlobID(new, true), FILE_MODE)
+		fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", ch.Old, ch.New)
+		b.WriteString(hunks)
+	}
+	return b.String()
+}
+
+// unifiedHunks formats the edit script from a to b as "@@" hunks with the
+// given number of context lines around each change.
+func unifiedHunks(a, b []string, context int, opts diffOptions) string {
+	ops := opts.lineOps(a, b)
+	var out strings.Builder
+	i := 0
+	for i < len(ops) {
+		for i < len(ops) && !opts.significant(ops[i], a, b) {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		// Changes separated by at most 2*context other lines share a hunk.
+		last := i
+		for j := i + 1; j < len(ops) && j-last-1 <= 2*context; j++ {
+			if opts.significant(ops[j], a, b) {
+				last = j
+			}
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := last + context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+		oldStart, newStart := ops[start].A, ops[start].B
+		if oldCount > 0 {
+			oldStart++
+		}
+		if newCount > 0 {
+			newStart++
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		if opts.wordDiff != "" {
+			out.WriteString(wordDiffHunk(ops[start:end], a, b, opts.wordDiff))
+		} else {
+			for _, op := range ops[start:end] {
+				var line string
+				if op.Kind == '-' {
+					line = a[op.A]
+				} else {
+					line = b[op.B]
+				}
+				out.WriteByte(op.Kind)
+				out.WriteString(line)
+				if !strings.HasSuffix(line, "\n") {
+					out.WriteString("\n\\ No newline at end of file\n")
+				}
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// isBinary reports whether content has a NUL byte near its start. Binary
+// files are not diffed line by line.
+func isBinary(content string) bool {
+	if len(content) > BINARY_CHECK_SIZE {
+		content = content[:BINARY_CHECK_SIZE]
+	}
+	return strings.IndexByte(content, 0) >= 0
+}
+
+// blobID hashes file content the way git hashes a blob, so that the
+// "index" lines of patches name the same versions in both tools.
+func blobID(content string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content)))
+	return hex.EncodeToString(sum[:])
+}
+
+// shortBlobID abbreviates a blob ID for an "index" line; a missing file is
+// all zeros.
+func shortBlobID(content string, exists bool) string {
+	if !exists {
+		return ZERO_ID[:7]
+	}
+	return blobID(content)[:7]
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+/* ----------------------------------------
+   Word diffs
+-------------------------------------------*/
+
+// wordDiffHunk shows a hunk without line prefixes: unchanged lines as they
+// are and each run of changed lines as one text in which removed words are
+// marked [-like this-] and added words {+like this+} (or red and green with
+// mode "color").
+func wordDiffHunk(ops []diffOp, a, b []string, mode string) string {
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			out.WriteString(b[ops[i].B])
+			if !strings.HasSuffix(b[ops[i].B], "\n") {
+				out.WriteByte('\n')
+			}
+			i++
+			continue
+		}
+		var old, new strings.Builder
+		for ; i < len(ops) && ops[i].Kind != ' '; i++ {
+			if ops[i].Kind == '-' {
+				old.WriteString(a[ops[i].A])
+			} else {
+				new.WriteString(b[ops[i].B])
+			}
+		}
+		text := wordDiff(old.String(), new.String(), mode)
+		out.WriteString(text)
+		if !strings.HasSuffix(text, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+// wordDiff marks the words that differ between two texts.
+func wordDiff(old, new, mode string) string {
+	a, b := splitWords(old), splitWords(new)
+	open := map[byte]string{'-': "[-", '+': "{+"}
+	close := map[byte]string{'-': "-]", '+': "+}"}
+	if mode == "color" {
+		open = map[byte]string{'-': COLOR_RED, '+': COLOR_GREEN}
+		close = map[byte]string{'-': COLOR_RESET, '+': COLOR_RESET}
+	}
+	var out strings.Builder
+	ops := diffLines(a, b)
+	for i := 0; i < len(ops); {
+		kind := ops[i].Kind
+		if kind == ' ' {
+			out.WriteString(b[ops[i].B])
+			i++
+			continue
+		}
+		var run strings.Builder
+		for ; i < len(ops) && ops[i].Kind == kind; i++ {
+			if kind == '-' {
+				run.WriteString(a[ops[i].A])
+			} else {
+				run.WriteString(b[ops[i].B])
+			}
+		}
+		// Keep line breaks outside the markers so each line stays whole;
+		// only the new text's line breaks are written.
+		text := run.String()
+		trimmed := strings.TrimRight(text, "\n")
+		if trimmed != "" {
+			out.WriteString(open[kind] + trimmed + close[kind])
+		}
+		if kind == '+' {
+			out.WriteString(text[len(trimmed):])
+		}
+	}
+	return out.String()
+}
+
+// splitWords splits text into alternating runs of whitespace and of other
+// characters, so that joining them gives back the text.
+func splitWords(s string) []string {
+	var words []string
+	start, space := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+/* ----------------------------------------
+   gud diff
+-------------------------------------------*/
+
+func diffUsage() {
+	fmt.Println("Usage: gud diff [--cached] [<revision> [<revision>] | <A>..<B> | <A>...<B>] [--] [<path>...]")
+	fmt.Println("       [--stat | --numstat | --name-only | --name-status] [--word-diff[=plain|color] | --color-words]")
+	fmt.Println("       [-w | --ignore-all-space] [-b | --ignore-space-change] [--ignore-blank-lines] [-M[<n>]] [-C[<n>]]")
+}
+
+// diffCommand compares the working tree with the index (the default), the
+// index with a commit (--cached, HEAD by default), the working tree with a
+// commit, or two commits.
+func diffCommand(args []string) {
+	args, opts, err := diffFlags(args, defaultDiffOptions())
+	if err != nil {
+		fmt.Println("Error:", err)
+		diffUsage()
+		return
+	}
+	mode := "patch"
+	cached := false
+	var positional, paths []string
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; a {
+		case "--cached", "--staged":
+			cached = true
+		case "--stat", "--numstat", "--name-only", "--name-status":
+			mode = a[2:]
+		case "-p", "--patch":
+			mode = "patch"
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			if strings.HasPrefix(a, "-") {
+				fmt.Println("Error: unknown option:", a)
+				diffUsage()
+				return
+			}
+			positional = append(positional, a)
+		}
+	}
+
+	// Without "--", arguments that are not revisions are paths, and must
+	// exist.
+	var revs []string
+	for n, a := range positional {
+		if _, err := resolveDiffRevisions(append(revs, a)); err != nil {
+			for _, p := range positional[n:] {
+				if _, statErr := os.Stat(p); statErr != nil {
+					fmt.Println("Error: unknown revision or path not in the working tree:", p)
+					return
+				}
+			}
+			paths = append(positional[n:], paths...)
+			break
+		}
+		revs = append(revs, a)
+	}
+	ids, err := resolveDiffRevisions(revs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	head := getLastCommitFiles()
+	index := indexFiles(head)
+	var a, b map[string]string
+	switch {
+	case len(ids) == 2:
+		a, b = commitFiles(ids[0]), commitFiles(ids[1])
+	case cached:
+		a, b = head, index
+		if len(ids) == 1 {
+			a = commitFiles(ids[0])
+		}
+	case len(ids) == 1:
+		a, b = commitFiles(ids[0]), trackedWorkingFiles(index)
+	default:
+		a, b = index, trackedWorkingFiles(index)
+	}
+	if len(ids) == 2 && cached {
+		diffUsage()
+		return
+	}
+	a, b = limitFiles(a, paths), limitFiles(b, paths)
+
+	if mode == "patch" {
+		fmt.Print(treePatch(a, b, opts))
+		return
+	}
+	fmt.Print(diffSummary(mode, opts, a, b))
+}
+
+// resolveDiffRevisions resolves up to two revisions, or one "A..B" (A
+// against B) or "A...B" (the merge base of A and B against B).
+func resolveDiffRevisions(revs []string) ([]string, error) {
+	if len(revs) > 2 {
+		return nil, fmt.Errorf("too many revisions")
+	}
+	var ids []string
+	for _, rev := range revs {
+		sep := ""
+		if strings.Contains(rev, "...") {
+			sep = "..."
+		} else if strings.Contains(rev, "..") {
+			sep = ".."
+		}
+		if sep == "" {
+			id, err := resolveRevision(rev)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+			continue
+		}
+		if len(revs) > 1 {
+			return nil, fmt.Errorf("a range cannot be combined with another revision")
+		}
+		parts := strings.SplitN(rev, sep, 2)
+		for i := range parts {
+			if parts[i] == "" {
+				parts[i] = "HEAD"
+			}
+		}
+		from, err := resolveRevision(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := resolveRevision(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if sep == "..." {
+			if from = mergeBase(from, to); from == "" {
+				return nil, fmt.Errorf("%s and %s have no common ancestor", parts[0], parts[1])
+			}
+		}
+		ids = append(ids, from, to)
+	}
+	return ids, nil
+}
+
+// trackedWorkingFiles reads the working copies of the files in index. Files
+// that were deleted from the working tree are left out.
+func trackedWorkingFiles(index map[string]string) map[string]string {
+	files := make(map[string]string)
+	for p := range index {
+		if content, ok := readWorkingFile(p); ok {
+			files[p] = content
+		}
+	}
+	return files
+}
+
+// diffSummary renders --stat, --numstat, --name-only or --name-status.
+func diffSummary(mode string, opts diffOptions, a, b map[string]string) string {
+	var out strings.Builder
+	type stat struct {
+		name           string
+		added, deleted int
+		binary         bool
+		from, to       int // sizes in bytes of a binary file
+	}
+	var stats []stat
+	for _, ch := range opts.detect.changes(a, b) {
+		old, new := a[ch.Old], b[ch.New]
+		binary := isBinary(old) || isBinary(new)
+		added, deleted := 0, 0
+		if !binary {
+			added, deleted = opts.lineCounts(old, new)
+			if ch.Status == 'M' && added == 0 && deleted == 0 {
+				continue // only ignored whitespace changed
+			}
+		}
+		name := changePath(ch)
+		switch mode {
+		case "name-only":
+			out.WriteString(name + "\n")
+			continue
+		case "name-status":
+			if ch.Status == 'R' || ch.Status == 'C' {
+				fmt.Fprintf(&out, "%c%03d\t%s\t%s\n", ch.Status, ch.Score, ch.Old, ch.New)
+			} else {
+				fmt.Fprintf(&out, "%c\t%s\n", ch.Status, name)
+			}
+			continue
+		}
+		if ch.Status == 'R' || ch.Status == 'C' {
+			name = ch.Old + " => " + ch.New
+		}
+		if mode == "numstat" {
+			if binary {
+				fmt.Fprintf(&out, "-\t-\t%s\n", name)
+			} else {
+				fmt.Fprintf(&out, "%d\t%d\t%s\n", added, deleted, name)
+			}
+			continue
+		}
+		stats = append(stats, stat{name, added, deleted, binary, len(old), len(new)})
+	}
+	if mode != "stat" || len(stats) == 0 {
+		return out.String()
+	}
+
+	nameWidth, maxChanges, totalAdded, totalDeleted := 0, 0, 0, 0
+	for _, s := range stats {
+		nameWidth = max(nameWidth, len(s.name))
+		maxChanges = max(maxChanges, s.added+s.deleted)
+		totalAdded += s.added
+		totalDeleted += s.deleted
+	}
+	countWidth := len(fmt.Sprint(maxChanges))
+	const graphWidth = 50
+	for _, s := range stats {
+		if s.binary {
+			fmt.Fprintf(&out, " %-*s | Bin %d -> %d bytes\n", nameWidth, s.name, s.from, s.to)
+			continue
+		}
+		plus, minus := s.added, s.deleted
+		if maxChanges > graphWidth {
+			plus = (plus*graphWidth + maxChanges - 1) / maxChanges
+			minus = (minus*graphWidth + maxChanges - 1) / maxChanges
+		}
+		fmt.Fprintf(&out, " %-*s | %*d %s%s\n", nameWidth, s.name, countWidth, s.added+s.deleted, strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	summary := fmt.Sprintf(" %d file%s changed", len(stats), plural(len(stats)))
+	if totalAdded > 0 || totalDeleted == 0 {
+		summary += fmt.Sprintf(", %d insertion%s(+)", totalAdded, plural(totalAdded))
+	}
+	if totalDeleted > 0 || totalAdded == 0 {
+		summary += fmt.Sprintf(", %d deletion%s(-)", totalDeleted, plural(totalDeleted))
+	}
+	out.WriteString(summary + "\n")
+	return out.String()
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBinaryFilePatch(t *testing.T) {
+	bin := "a\x00b\nc\n"
+	tests := []struct {
+		name           string
+		old, new       string
+		hasOld, hasNew bool
+		want           string
+	}{
+		{"modified", bin, bin + "d\n", true, true, "Binary files a/f and b/f differ\n"},
+		{"added", "", bin, false, true, "Binary files /dev/null and b/f differ\n"},
+		{"deleted", bin, "", true, false, "Binary files a/f and /dev/null differ\n"},
+		{"became binary", "text\n", bin, true, true, "Binary files a/f and b/f differ\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch := filePatch("f", tt.old, tt.new, tt.hasOld, tt.hasNew, defaultDiffOptions())
+			if !strings.HasSuffix(patch, tt.want) {
+				t.Errorf("patch ends %q, want %q", patch, tt.want)
+			}
+			if strings.Contains(patch, "@@") {
+				t.Errorf("binary file diffed line by line:\n%s", patch)
+			}
+		})
+	}
+	if patch := filePatch("f", bin, bin, true, true, defaultDiffOptions()); patch != "" {
+		t.Errorf("unchanged binary file gives %q", patch)
+	}
+}
+
+func TestBinaryFileStat(t *testing.T) {
+	a := map[string]string{"bin": "a\x00b\n", "txt": "t\n"}
+	b := map[string]string{"bin": "a\x00b\nc\n", "txt": "u\n"}
+	opts := defaultDiffOptions()
+
+	stat := diffSummary("stat", opts, a, b)
+	if !strings.Contains(stat, " bin | Bin 4 -> 6 bytes\n") {
+		t.Errorf("--stat has no binary entry:\n%s", stat)
+	}
+	if !strings.Contains(stat, " 2 files changed, 1 insertion(+), 1 deletion(-)") {
+		t.Errorf("--stat summary counts the binary file's lines:\n%s", stat)
+	}
+	if numstat := diffSummary("numstat", opts, a, b); !strings.Contains(numstat, "-\t-\tbin\n") {
+		t.Errorf("--numstat = %q, want - - for the binary file", numstat)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ----------------------------------------
+   Remote-tracking branches, fetch and upstreams
+-------------------------------------------*/
+
+const REMOTE_REFS_DIR = ".gud/refs/remotes"
+
+// loadRemoteRefs returns the last known branch heads of a remote, keyed by
+// branch name (refs/remotes/<remote>/<branch>).
+func loadRemoteRefs(remote string) map[string]string {
+	return loadRemoteRefsAt(GUD_DIR, remote)
+}
+
+func loadRemoteRefsAt(gudDir, remote string) map[string]string {
+	refs := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(gudDir, "refs", "remotes", remote+".json"))
+	if err == nil {
+		json.Unmarshal(data, &refs)
+	}
+	if refs == nil {
+		refs = make(map[string]string)
+	}
+	return refs
+}
+
+// trackedRemotesAt lists the remotes that have remote-tracking branches,
+// whether or not they are still configured.
+func trackedRemotesAt(gudDir string) []string {
+	paths, _ := filepath.Glob(filepath.Join(gudDir, "refs", "remotes", "*.json"))
+	var remotes []string
+	for _, p := range paths {
+		remotes = append(remotes, strings.TrimSuffix(filepath.Base(p), ".json"))
+	}
+	return remotes
+}
+
+func saveRemoteRefs(remote string, refs map[string]string) {
+	os.MkdirAll(REMOTE_REFS_DIR, 0755)
+	data, _ := json.MarshalIndent(refs, "", "  ")
+	os.WriteFile(filepath.Join(REMOTE_REFS_DIR, remote+".json"), data, 0644)
+}
+
+// remoteTrackingRef resolves "<remote>/<branch>", optionally prefixed with
+// "remotes/" or "refs/remotes/".
+func remoteTrackingRef(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "refs/")
+	name = strings.TrimPrefix(name, "remotes/")
+	i := strings.Index(name, "/")
+	if i <= 0 {
+		return "", false
+	}
+	id, ok := loadRemoteRefs(name[:i])[name[i+1:]]
+	return id, ok && id != ""
+}
+
+// allRemoteTrackingHeads lists every remote-tracking branch tip.
+func allRemoteTrackingHeads() []string {
+	var heads []string
+	for _, remote := range remoteNames() {
+		for _, id := range loadRemoteRefs(remote) {
+			heads = append(heads, id)
+		}
+	}
+	return heads
+}
+
+// branchUpstream returns the remote and remote branch a local branch tracks.
+func branchUpstream(branch string) (string, string, bool) {
+	remote, ok := configValue("branch." + branch + ".remote")
+	if !ok {
+		return "", "", false
+	}
+	merge, ok := configValue("branch." + branch + ".merge")
+	if !ok {
+		return "", "", false
+	}
+	return remote, strings.TrimPrefix(merge, "refs/heads/"), true
+}
+
+func setBranchUpstream(branch, remote, remoteBranch string) error {
+	scope, _ := configScopeByName("local")
+	if err := setConfigValue(scope, "branch."+branch+".remote", remote); err != nil {
+		return err
+	}
+	return setConfigValue(scope, "branch."+branch+".merge", "refs/heads/"+remoteBranch)
+}
+
+func setUpstreamCommand(upstream, branch string) {
+	if branch == "" {
+		branch = currentBranch()
+	}
+	i := strings.Index(upstream, "/")
+	if i <= 0 {
+		fmt.Println("Upstream must be <remote>/<branch>:", upstream)
+		return
+	}
+	remote, remoteBranch := upstream[:i], upstream[i+1:]
+	if _, ok := remoteURL(remote); !ok {
+		fmt.Println("No such remote:", remote)
+		return
+	}
+	if err := setBranchUpstream(branch, remote, remoteBranch); err != nil {
+		fmt.Println("Error saving config:", err)
+		return
+	}
+	fmt.Printf("Branch '%s' set up to track '%s/%s'.\n", branch, remote, remoteBranch)
+}
+
+func unsetUpstreamCommand(branch string) {
+	if branch == "" {
+		branch = currentBranch()
+	}
+	scope, _ := configScopeByName("local")
+	unsetConfigValue(scope, "branch."+branch+".remote")
+	unsetConfigValue(scope, "branch."+branch+".merge")
+	fmt.Printf("Branch '%s' no longer tracks an upstream.\n", branch)
+}
+
+// defaultRemote is the upstream remote of the current branch, or origin.
+func defaultRemote() string {
+	if remote, _, ok := branchUpstream(currentBranch()); ok {
+		return remote
+	}
+	return DEFAULT_REMOTE
+}
+
+// aheadBehind counts the commits only in local and only in upstream.
+func aheadBehind(local, upstream string) (int, int) {
+	fromLocal := reachableCommitsAt(GUD_DIR, []string{local}, nil)
+	fromUpstream := reachableCommitsAt(GUD_DIR, []string{upstream}, nil)
+	ahead, behind := 0, 0
+	for id := range fromLocal {
+		if !fromUpstream[id] {
+			ahead++
+		}
+	}
+	for id := range fromUpstream {
+		if !fromLocal[id] {
+			behind++
+		}
+	}
+	return ahead, behind
+}
+
+// upstreamStatus describes how the current branch relates to its upstream.
+func upstreamStatus() string {
+	branch := currentBranch()
+	remote, remoteBranch, ok := branchUpstream(branch)
+	if !ok {
+		return ""
+	}
+	name := remote + "/" + remoteBranch
+	upstream, ok := loadRemoteRefs(remote)[remoteBranch]
+	if !ok {
+		return fmt.Sprintf("Your branch is based on '%s', but the upstream is gone.", name)
+	}
+	ahead, behind := aheadBehind(currentBranchHead(), upstream)
+	switch {
+	case ahead == 0 && behind == 0:
+		return fmt.Sprintf("Your branch is up to date with '%s'.", name)
+	case behind == 0:
+		return fmt.Sprintf("Your branch is ahead of '%s' by %d commit(s).", name, ahead)
+	case ahead == 0:
+		return fmt.Sprintf("Your branch is behind '%s' by %d commit(s).", name, behind)
+	}
+	return fmt.Sprintf("Your branch and '%s' have diverged (ahead %d, behind %d).", name, ahead, behind)
+}
+
+// fetchOptions control what fetchRemote does besides updating
+// remote-tracking branches.
+type fetchOptions struct {
+	Prune     bool // drop tracking branches deleted on the remote
+	NoTags    bool // do not fetch tags
+	ForceTags bool // let fetched tags replace local tags of the same name
+}
+
+const FETCH_USAGE = "Usage: gud fetch [--all] [--prune] [--tags|--no-tags] [--force] [remote]"
+
+func fetchCommand(args []string) {
+	var opts fetchOptions
+	all := false
+	var positional []string
+	for _, a := range args {
+		switch a {
+		case "--prune", "-p":
+			opts.Prune = true
+		case "--all":
+			all = true
+		case "--no-tags", "-n":
+			opts.NoTags = true
+		case "--tags", "-t":
+			// Every tag is fetched by default; --tags undoes an earlier --no-tags.
+			opts.NoTags = false
+		case "--force", "-f":
+			opts.ForceTags = true
+		default:
+			if strings.HasPrefix(a, "-") {
+				fmt.Println("Error: unknown option:", a)
+				fmt.Println(FETCH_USAGE)
+				return
+			}
+			positional = append(positional, a)
+		}
+	}
+	if len(positional) > 1 {
+		fmt.Println(FETCH_USAGE)
+		return
+	}
+	remotes := []string{defaultRemote()}
+	if len(positional) == 1 {
+		remotes = positional
+	}
+	if all {
+		remotes = remoteNames()
+	}
+	for _, remote := range remotes {
+		fetchRemote(remote, opts)
+	}
+}
+
+// fetchRemote downloads the objects of every branch and tag of a remote,
+// updates its remote-tracking branches and creates its tags locally. Remotes
+// given as a literal path or URL have no tracking branches. It returns the
+// remote's advertisement.
+func fetchRemote(remote string, opts fetchOptions) (*refAdvertisement, bool) {
+	if remote == "" {
+		remote = defaultRemote()
+	}
+	url, err := resolveRemote(remote)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil, false
+	}
+	t, err := openTransport(url, false)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil, false
+	}
+	defer t.close()
+
+	adv, err := t.listRefs()
+	if err != nil {
+		fmt.Println("Error reading remote refs:", err)
+		return nil, false
+	}
+
+	var wants []string
+	for ref, id := range adv.Refs {
+		if opts.NoTags && strings.HasPrefix(ref, "refs/tags/") {
+			continue
+		}
+		if !hasObjectAt(GUD_DIR, id) {
+			wants = append(wants, id)
+		}
+	}
+	sort.Strings(wants)
+	if len(wants) > 0 {
+		pack, err := t.fetchObjects(wants, localHaves(), 0)
+		if err != nil {
+			fmt.Println("Error fetching objects:", err)
+			return nil, false
+		}
+		ids, err := storePackAt(GUD_DIR, pack)
+		if err != nil {
+			fmt.Println("Error storing objects:", err)
+			return nil, false
+		}
+		fmt.Printf("From %s: received %d objects\n", url, len(ids))
+	}
+
+	if _, named := remoteURL(remote); named {
+		updateRemoteTracking(remote, adv, opts.Prune)
+	}
+	if !opts.NoTags {
+		updateTagsFromRemote(adv, opts.ForceTags)
+	}
+	return adv, true
+}
+
+// updateTagsFromRemote creates the remote's tags locally. A local tag of the
+// same name that points elsewhere is kept unless force is set.
+func updateTagsFromRemote(adv *refAdvertisement, force bool) {
+	tags := loadTags()
+	changed := false
+	var names []string
+	for ref := range adv.Refs {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			names = append(names, strings.TrimPrefix(ref, "refs/tags/"))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		id := adv.Refs["refs/tags/"+name]
+		old, ok := tags[name]
+		switch {
+		case !validTagName(name) || !hasObjectAt(GUD_DIR, id) || old == id:
+			continue
+		case !ok:
+			fmt.Printf(" * [new tag]     %s -> %s\n", name, name)
+		case !force:
+			fmt.Printf(" ! [rejected]    %s -> %s (would clobber existing tag)\n", name, name)
+			continue
+		default:
+			fmt.Printf(" t [tag update]  %s -> %s\n", name, name)
+		}
+		tags[name] = id
+		changed = true
+	}
+	if changed {
+		saveTags(tags)
+	}
+}
+
+func updateRemoteTracking(remote string, adv *refAdvertisement, prune bool) {
+	tracking := loadRemoteRefs(remote)
+	var names []string
+	for ref := range adv.Refs {
+		if strings.HasPrefix(ref, "refs/heads/") {
+			names = append(names, strings.TrimPrefix(ref, "refs/heads/"))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		id := adv.Refs["refs/heads/"+name]
+		old, ok := tracking[name]
+		switch {
+		case !ok:
+			fmt.Printf(" * [new branch]  %s -> %s/%s\n", name, remote, name)
+		case old != id:
+			fmt.Printf("   %s..%s  %s -> %s/%s\n", shortID(old), shortID(id), name, remote, name)
+		}
+		tracking[name] = id
+	}
+	if prune {
+		for name := range tracking {
+			if _, ok := adv.Refs["refs/heads/"+name]; !ok {
+				delete(tracking, name)
+				fmt.Printf(" - [deleted]     %s/%s\n", remote, name)
+			}
+		}
+	}
+	saveRemoteRefs(remote, tracking)
+}
+
+func listRemoteBranches() {
+	for _, remote := range remoteNames() {
+		refs := loadRemoteRefs(remote)
+		var names []string
+		for name := range refs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s/%s\n", remote, name)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ----------------------------------------
+   Integrity checks: gud fsck
+-------------------------------------------*/
+
+type fsckReport struct {
+	errors  int
+	verbose bool
+	corrupt map[string]bool // objects stored but failing verification
+}
+
+func (r *fsckReport) errorf(format string, args ...interface{}) {
+	r.errors++
+	fmt.Printf("error: "+format+"\n", args...)
+}
+
+// absent describes an object that a reference names but that did not verify:
+// it is either stored but corrupt or not stored at all.
+func (r *fsckReport) absent(id string) string {
+	if r.corrupt[id] {
+		return "corrupt object " + id
+	}
+	return "missing commit " + id
+}
+
+func (r *fsckReport) warnf(format string, args ...interface{}) {
+	fmt.Printf("warning: "+format+"\n", args...)
+}
+
+// fsckCommand verifies every stored commit and tag object (its encoding, ID
+// and content hash), every pack, and that parents, tag objects and refs point
+// at existing objects.
+// It reports dangling commits and exits non-zero if anything is corrupt or
+// missing.
+func fsckCommand(args []string) {
+	gudDir, err := gcGudDir()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	report := &fsckReport{corrupt: make(map[string]bool)}
+	showDangling := true
+	for _, a := range args {
+		switch a {
+		case "--no-dangling":
+			showDangling = false
+		case "--verbose", "-v":
+			report.verbose = true
+		default:
+			fmt.Println("Usage: gud fsck [--no-dangling] [--verbose]")
+			return
+		}
+	}
+
+	commits := make(map[string]*Commit)
+	tagObjects := make(map[string]*TagObject)
+	fsckPacks(report, gudDir, commits, tagObjects)
+	for _, id := range looseObjectIDsAt(gudDir) {
+		path, kind, _ := looseObjectPathAt(gudDir, id)
+		if report.verbose {
+			fmt.Println("Checking object", id)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			report.errorf("cannot read object %s: %v", id, err)
+			continue
+		}
+		fsckObject(report, packObject{id, kind, data}, commits, tagObjects)
+	}
+
+	legacy := 0
+	for id := range commits {
+		if !isContentHashID(id) {
+			legacy++
+		}
+	}
+	if legacy > 0 {
+		report.warnf("%d commits have time-based IDs whose content cannot be verified", legacy)
+	}
+
+	// Parents must exist unless the commit is a shallow boundary.
+	shallow := loadShallowAt(gudDir)
+	referenced := make(map[string]bool)
+	ids := sortedCommitIDs(commits)
+	for _, id := range ids {
+		for _, p := range commits[id].Parents {
+			referenced[p] = true
+			if _, ok := commits[p]; !ok && !shallow[id] {
+				report.errorf("%s (parent of %s)", report.absent(p), id)
+			}
+		}
+	}
+	for id := range shallow {
+		if _, ok := commits[id]; !ok {
+			report.warnf("shallow commit %s is not in the repository", id)
+		}
+	}
+
+	checkRef := func(kind, name, id string) {
+		referenced[id] = true
+		if id == "" {
+			return
+		}
+		if _, ok := commits[id]; !ok {
+			report.errorf("%s %s points to %s", kind, name, report.absent(id))
+		}
+	}
+	// A tag may name a tag object, which must itself lead to a commit.
+	for _, id := range sortedTagObjectIDs(tagObjects) {
+		t := tagObjects[id]
+		referenced[t.Object] = true
+		if _, ok := commits[t.Object]; !ok && tagObjects[t.Object] == nil {
+			report.errorf("tag object %s (%s) points to %s", id, t.Tag, report.absent(t.Object))
+		}
+	}
+	branches := loadBranchesAt(gudDir)
+	for _, name := range sortedKeys(branches) {
+		checkRef("branch", name, branches[name])
+	}
+	head := "main"
+	if data, err := os.ReadFile(filepath.Join(gudDir, filepath.Base(CURRENT_BRANCH))); err == nil {
+		head = strings.TrimSpace(string(data))
+	}
+	if _, ok := branches[head]; !ok && len(commits) > 0 {
+		report.warnf("HEAD points to unborn branch %s", head)
+	}
+	tags := loadTagsAt(gudDir)
+	for _, name := range sortedKeys(tags) {
+		if _, ok := tagObjects[tags[name]]; ok {
+			referenced[tags[name]] = true
+			continue
+		}
+		checkRef("tag", name, tags[name])
+	}
+	for _, remote := range trackedRemotesAt(gudDir) {
+		refs := loadRemoteRefsAt(gudDir, remote)
+		for _, name := range sortedKeys(refs) {
+			checkRef("remote-tracking branch", remote+"/"+name, refs[name])
+		}
+	}
+	for n, id := range loadStashAt(gudDir) {
+		checkRef("stash", fmt.Sprintf("stash@{%d}", n), id)
+	}
+	seen := make(map[string]bool)
+	for _, id := range reflogIDsAt(gudDir) {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		checkRef("reflog", "entry", id)
+	}
+	if data, err := os.ReadFile(filepath.Join(gudDir, filepath.Base(MERGE_HEAD_FILE))); err == nil {
+		checkRef("MERGE_HEAD", "", strings.TrimSpace(string(data)))
+	}
+
+	if showDangling {
+		for _, id := range ids {
+			if !referenced[id] {
+				fmt.Println("dangling commit", id)
+			}
+		}
+		for _, id := range sortedTagObjectIDs(tagObjects) {
+			if !referenced[id] {
+				fmt.Println("dangling tag", id)
+			}
+		}
+	}
+
+	if report.errors > 0 {
+		fmt.Printf("fsck found %d error(s) in %d commits.\n", report.errors, len(commits))
+		os.Exit(1)
+	}
+	if report.verbose {
+		fmt.Printf("Checked %d commits.\n", len(commits))
+	}
+}
+
+// fsckObject verifies one object and records it by kind.
+func fsckObject(report *fsckReport, obj packObject, commits map[string]*Commit, tagObjects map[string]*TagObject) {
+	switch obj.Kind {
+	case OBJ_COMMIT:
+		c, err := verifyCommitData(obj.ID, obj.Data)
+		if err != nil {
+			report.corrupt[obj.ID] = true
+			report.errorf("%v", err)
+			return
+		}
+		commits[obj.ID] = c
+	case OBJ_TAG:
+		t, err := verifyTagData(obj.ID, obj.Data)
+		if err != nil {
+			report.corrupt[obj.ID] = true
+			report.errorf("%v", err)
+			return
+		}
+		tagObjects[obj.ID] = t
+	default:
+		report.errorf("object %s has unknown kind %d", obj.ID, obj.Kind)
+	}
+}
+
+// fsckPacks checks each pack's checksum, its index and every object in it.
+func fsckPacks(report *fsckReport, gudDir string, commits map[string]*Commit, tagObjects map[string]*TagObject) {
+	packs, _ := filepath.Glob(filepath.Join(packDirAt(gudDir), "pack-*.pack"))
+	sort.Strings(packs)
+	for _, path := range packs {
+		if report.verbose {
+			fmt.Println("Checking pack", filepath.Base(path))
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			report.errorf("cannot read pack %s: %v", path, err)
+			continue
+		}
+		objects, offsets, err := decodePack(data)
+		if err != nil {
+			report.errorf("pack %s: %v", filepath.Base(path), err)
+			continue
+		}
+		idx, err := readPackIndex(strings.TrimSuffix(path, ".pack") + ".idx")
+		if err != nil {
+			report.errorf("index of pack %s: %v", filepath.Base(path), err)
+		} else {
+			if len(idx.ids) != len(offsets) {
+				report.errorf("index of pack %s lists %d objects, pack holds %d", filepath.Base(path), len(idx.ids), len(offsets))
+			}
+			for i, id := range idx.ids {
+				if loc, ok := offsets[id]; !ok || loc.Offset != idx.offsets[i] || loc.Kind != idx.kinds[i] {
+					report.errorf("index of pack %s has a bad entry for %s", filepath.Base(path), id)
+				}
+			}
+		}
+		for _, o := range objects {
+			fsckObject(report, o, commits, tagObjects)
+		}
+	}
+}
+
+func sortedCommitIDs(commits map[string]*Commit) []string {
+	ids := make([]string, 0, len(commits))
+	for id := range commits {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sortedTagObjectIDs(tagObjects map[string]*TagObject) []string {
+	ids := make([]string, 0, len(tagObjects))
+	for id := range tagObjects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
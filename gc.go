@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   Garbage collection: gud gc / gud prune
+-------------------------------------------*/
+
+const DEFAULT_PRUNE_EXPIRE = "2.weeks.ago"
+
+// gcGudDir returns the repository gc and prune work on: .gud in a working
+// tree, or the current directory when it is a bare repository.
+func gcGudDir() (string, error) {
+	if info, err := os.Stat(GUD_DIR); err == nil && info.IsDir() {
+		return GUD_DIR, nil
+	}
+	if isBareRepoAt(".") {
+		return ".", nil
+	}
+	return "", fmt.Errorf("not a gud repository (no %s here and not a bare repository)", GUD_DIR)
+}
+
+// gcRoots lists every object that keeps history alive: branch heads, tags,
+// remote-tracking branches, stash entries, reflog entries, an in-progress
+// merge and the commits of a stopped cherry-pick or revert.
+func gcRoots(gudDir string) []string {
+	var roots []string
+	for _, id := range loadBranchesAt(gudDir) {
+		roots = append(roots, id)
+	}
+	for _, id := range loadTagsAt(gudDir) {
+		roots = append(roots, id)
+	}
+	for _, remote := range trackedRemotesAt(gudDir) {
+		for _, id := range loadRemoteRefsAt(gudDir, remote) {
+			roots = append(roots, id)
+		}
+	}
+	roots = append(roots, loadStashAt(gudDir)...)
+	roots = append(roots, reflogIDsAt(gudDir)...)
+	for _, f := range []string{MERGE_HEAD_FILE, CHERRY_PICK_HEAD_FILE, REVERT_HEAD_FILE} {
+		if data, err := os.ReadFile(filepath.Join(gudDir, filepath.Base(f))); err == nil {
+			roots = append(roots, strings.TrimSpace(string(data)))
+		}
+	}
+	var seq sequencerState
+	if data, err := os.ReadFile(filepath.Join(gudDir, filepath.Base(SEQUENCER_FILE))); err == nil && json.Unmarshal(data, &seq) == nil {
+		roots = append(roots, seq.Head)
+		roots = append(roots, seq.Todo...)
+	}
+	return roots
+}
+
+// parseExpiry turns "now", "never", an absolute date or a relative time such
+// as "2.weeks.ago" or "3 days ago" into a cutoff: objects last modified at or
+// before it may be pruned. "never" returns the zero time.
+func parseExpiry(s string) (time.Time, error) {
+	now := time.Now()
+	switch s {
+	case "now", "all":
+		return now, nil
+	case "never":
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == ' ' })
+	if len(fields) == 3 && fields[2] == "ago" {
+		n, err := strconv.Atoi(fields[0])
+		if err == nil && n >= 0 {
+			units := map[string]time.Duration{
+				"second": time.Second,
+				"minute": time.Minute,
+				"hour":   time.Hour,
+				"day":    24 * time.Hour,
+				"week":   7 * 24 * time.Hour,
+				"month":  30 * 24 * time.Hour,
+				"year":   365 * 24 * time.Hour,
+			}
+			if unit, ok := units[strings.TrimSuffix(fields[1], "s")]; ok {
+				return now.Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry time: %s", s)
+}
+
+func expired(mtime, cutoff time.Time) bool {
+	return !cutoff.IsZero() && !mtime.After(cutoff)
+}
+
+// reachableObjects marks every commit and tag object the roots lead to.
+func reachableObjects(gudDir string) map[string]bool {
+	heads, tagObjects := peelAt(gudDir, gcRoots(gudDir))
+	reachable := reachableCommitsAt(gudDir, heads, nil)
+	for id := range tagObjects {
+		reachable[id] = true
+	}
+	return reachable
+}
+
+type unreachableObject struct {
+	ID     string
+	Path   string // loose objects only
+	Packed bool   // only stored in a pack
+	Pack   *packIndex
+	Mtime  time.Time
+}
+
+// unreachableObjects lists stored objects that no root leads to. A packed
+// object's age is the age of its pack.
+func unreachableObjects(gudDir string, reachable map[string]bool) []unreachableObject {
+	var result []unreachableObject
+	loose := make(map[string]bool)
+	for _, id := range looseObjectIDsAt(gudDir) {
+		loose[id] = true
+		if reachable[id] {
+			continue
+		}
+		path, _, _ := looseObjectPathAt(gudDir, id)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		result = append(result, unreachableObject{ID: id, Path: path, Mtime: info.ModTime()})
+	}
+	for _, idx := range loadPackIndexesAt(gudDir) {
+		info, err := os.Stat(idx.packPath)
+		if err != nil {
+			continue
+		}
+		for _, id := range idx.ids {
+			if reachable[id] || loose[id] {
+				continue
+			}
+			loose[id] = true
+			result = append(result, unreachableObject{ID: id, Packed: true, Pack: idx, Mtime: info.ModTime()})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func pruneExpiry(arg string) (time.Time, error) {
+	if arg == "" {
+		arg = configString("gc.pruneExpire", DEFAULT_PRUNE_EXPIRE)
+	}
+	return parseExpiry(arg)
+}
+
+// gcCommand expires old reflog entries, packs every reachable object into a
+// single pack and deletes unreachable objects older than the grace period.
+// Younger unreachable objects that were packed are written back as loose
+// objects, keeping their age, so a later gc can still expire them.
+func gcCommand(args []string) {
+	expire := ""
+	dryRun := false
+	for _, a := range args {
+		switch {
+		case a == "--dry-run" || a == "-n":
+			dryRun = true
+		case a == "--no-prune":
+			expire = "never"
+		case a == "--prune":
+			expire = "now"
+		case strings.HasPrefix(a, "--prune="):
+			expire = strings.TrimPrefix(a, "--prune=")
+		default:
+			fmt.Println("Usage: gud gc [--prune=<time> | --no-prune] [--dry-run]")
+			return
+		}
+	}
+	gudDir, err := gcGudDir()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	cutoff, err := pruneExpiry(expire)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	reflogCutoff, err := reflogExpiry("")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n := expireReflogsAt(gudDir, reflogCutoff, dryRun); n > 0 && dryRun {
+		fmt.Printf("Would expire %d reflog entries.\n", n)
+	}
+
+	reachable := reachableObjects(gudDir)
+	unreachable := unreachableObjects(gudDir, reachable)
+	removed := 0
+	for _, o := range unreachable {
+		if !expired(o.Mtime, cutoff) {
+			continue
+		}
+		removed++
+		if dryRun {
+			fmt.Println("Would remove", o.ID)
+		}
+	}
+	if dryRun {
+		fmt.Printf("Would remove %d unreachable objects, keeping %d reachable.\n", removed, len(reachable))
+		return
+	}
+
+	for _, o := range unreachable {
+		if !expired(o.Mtime, cutoff) {
+			if o.Packed {
+				if err := explodeObject(gudDir, o); err != nil {
+					fmt.Println("Error unpacking object:", err)
+					return
+				}
+			}
+			continue
+		}
+		if !o.Packed {
+			os.Remove(o.Path)
+		}
+	}
+
+	var ids []string
+	for id := range reachable {
+		if hasObjectAt(gudDir, id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	oldPacks := loadPackIndexesAt(gudDir)
+	newPath := ""
+	if len(ids) > 0 {
+		if newPath, err = writePackAt(gudDir, ids); err != nil {
+			fmt.Println("Error repacking:", err)
+			return
+		}
+	}
+	removeLooseAt(gudDir, reachable)
+	removePacksAt(gudDir, oldPacks, newPath)
+	fmt.Printf("Packed %d objects, removed %d unreachable objects.\n", len(ids), removed)
+}
+
+// explodeObject writes a packed object back as a loose one with the pack's
+// modification time.
+func explodeObject(gudDir string, o unreachableObject) error {
+	obj, err := o.Pack.readObject(o.ID, 0)
+	if err != nil {
+		return err
+	}
+	dir := looseObjectDirAt(gudDir, obj.Kind)
+	path := filepath.Join(dir, o.ID+".json")
+	os.MkdirAll(dir, 0755)
+	if err := os.WriteFile(path, obj.Data, 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(path, o.Mtime, o.Mtime)
+}
+
+// pruneCommand deletes unreachable loose objects older than the grace
+// period. Packed objects are left to gc.
+func pruneCommand(args []string) {
+	expire := ""
+	dryRun := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--dry-run" || args[i] == "-n":
+			dryRun = true
+		case args[i] == "--expire" && i+1 < len(args):
+			expire = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--expire="):
+			expire = strings.TrimPrefix(args[i], "--expire=")
+		default:
+			fmt.Println("Usage: gud prune [--expire <time>] [--dry-run]")
+			return
+		}
+	}
+	gudDir, err := gcGudDir()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	cutoff, err := pruneExpiry(expire)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	reachable := reachableObjects(gudDir)
+	removed := 0
+	for _, o := range unreachableObjects(gudDir, reachable) {
+		if o.Packed || !expired(o.Mtime, cutoff) {
+			continue
+		}
+		removed++
+		if dryRun {
+			fmt.Println("Would remove", o.ID)
+			continue
+		}
+		os.Remove(o.Path)
+		fmt.Println("Removed", o.ID)
+	}
+	if removed == 0 {
+		fmt.Println("Nothing to prune.")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGCKeepsStoppedPickCommits(t *testing.T) {
+	newTestRepo(t)
+	commitFilesForTest(t, "base", map[string]string{"f": "a\n"})
+	checkoutCommand([]string{"-b", "topic"})
+	one := commitFilesForTest(t, "one", map[string]string{"f": "one\n"})
+	two := commitFilesForTest(t, "two", map[string]string{"h": "two\n"})
+	checkoutCommand([]string{"main"})
+	commitFilesForTest(t, "main change", map[string]string{"f": "main\n"})
+
+	// Leave one and two reachable from nothing but the cherry-pick.
+	deleteBranch("topic")
+	os.RemoveAll(REFLOG_DIR)
+	cherryPickCommand([]string{one, two})
+	if !pickStopped() {
+		t.Fatal("cherry-pick did not stop on the conflict")
+	}
+	os.RemoveAll(REFLOG_DIR)
+
+	gcCommand([]string{"--prune=now"})
+	for _, id := range []string{one, two} {
+		if !hasCommit(id) {
+			t.Fatalf("gc removed %s, which the cherry-pick still needs", shortID(id))
+		}
+	}
+
+	commitFilesForTest(t, "take one", map[string]string{"f": "main and one\n"})
+	cherryPickCommand([]string{"--continue"})
+	if got := readTestFile(t, "h"); got != "two\n" {
+		t.Errorf("h = %q, want the second pick applied after gc", got)
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 	"regexp"
 )
 
@@ -17,14 +17,15 @@ const (
 	BRANCHES_DIR      = ".gud/branches"
 	CURRENT_BRANCH    = ".gud/HEAD"
 	STAGING_FILE      = ".gud/staging_area"
+	STAGING_REMOVED_FILE = ".gud/staging_removed"
 	CURRENT_BRANCH_FILE = ".gud/HEAD"
-	REMOTE_DIR        = ".gud_remote"
 	TAGS_FILE         = ".gud/tags"
 	LOG_FILE          = ".gud/logs"
 	COMMITS_DIR       = ".gud/commits"
 	REMOTE_URL_FILE   = ".gud/remote_url"
 	IGNORE_FILE       = ".gudignore"
 	CONFIG_FILE       = ".gud/config.json"
+	SHALLOW_FILE      = ".gud/shallow"
 )
 
 type Commit struct {
@@ -33,23 +34,36 @@ type Commit struct {
 	Timestamp string            `json:"timestamp"`
 	Files     map[string]string `json:"files"`  // filepath -> content
 	Branch    string            `json:"branch"`
-}
-
-type Config struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	Author    string            `json:"author,omitempty"`
+	Committer string            `json:"committer,omitempty"`
+	Parents   []string          `json:"parents,omitempty"`
+	Signature *Signature        `json:"signature,omitempty"`
 }
 
 func showRemoteURL() {
-	data, err := os.ReadFile(REMOTE_URL_FILE)
-	if err != nil {
-		fmt.Println("No remote URL configured.")
-		return
+	url, ok := configValue("remote.origin.url")
+	if !ok {
+		data, err := os.ReadFile(REMOTE_URL_FILE)
+		if err != nil {
+			fmt.Println("No remote URL configured.")
+			return
+		}
+		url = string(data)
 	}
-	fmt.Println("Remote URL:", string(data))
+	fmt.Println("Remote URL:", url)
 }
 
 func main() {
+	// Invoked over ssh as gud-upload-pack / gud-receive-pack.
+	switch filepath.Base(os.Args[0]) {
+	case UPLOAD_PACK_COMMAND:
+		uploadPackCommand(os.Args[1:])
+		return
+	case RECEIVE_PACK_COMMAND:
+		receivePackCommand(os.Args[1:])
+		return
+	}
+
 	if len(os.Args) < 2 {
 		fmt.Println("Usage: gud <command> [args]")
 		return
@@ -57,7 +71,18 @@ func main() {
 	cmd := os.Args[1]
 	switch cmd {
 	case "init":
-		initRepo()
+		switch {
+		case len(os.Args) == 2:
+			initRepo()
+		case os.Args[2] == "--bare" && len(os.Args) <= 4:
+			dir := "."
+			if len(os.Args) == 4 {
+				dir = os.Args[3]
+			}
+			initBareRepo(dir)
+		default:
+			fmt.Println("Usage: gud init [--bare [<dir>]]")
+		}
 	case "add":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: gud add <file>")
@@ -79,19 +104,25 @@ func main() {
 	case "status":
 		status()
 	case "diff":
-		diff()
+		diffCommand(os.Args[2:])
 	case "commit":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: gud commit <message>")
+		args, sign := signFlag(os.Args[2:], configBool("commit.gpgSign", false))
+		args, verify := noVerifyFlag(args)
+		msg := commitMessage(args)
+		if msg == "" {
+			fmt.Println("Aborting commit due to empty commit message.")
 			return
 		}
-		createCommit(strings.Join(os.Args[2:], " "))
+		createCommit(msg, "", "", sign, verify)
 	case "amend":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: gud amend <new message>")
+		args, verify := noVerifyFlag(os.Args[2:])
+		if len(args) < 1 {
+			fmt.Println("Usage: gud amend [--no-verify] <new message>")
 			return
 		}
-		amendLastCommit(strings.Join(os.Args[2:], " "))
+		amendLastCommit(strings.Join(args, " "), verify)
+	case "checkout":
+		checkoutCommand(os.Args[2:])
 	case "restore":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: gud restore <commit_id>")
@@ -101,11 +132,17 @@ func main() {
 	case "branch":
 		handleBranchCommand(os.Args[2:])
 	case "merge":
-		if len(os.Args) != 4 {
-			fmt.Println("Usage: gud merge <base> <target>")
-			return
+		args, verify := noVerifyFlag(os.Args[2:])
+		switch {
+		case len(args) == 1 && args[0] == "--abort":
+			abortMerge()
+		case len(args) == 1:
+			mergeBranches(currentBranch(), args[0], verify)
+		case len(args) == 2:
+			mergeBranches(args[0], args[1], verify)
+		default:
+			fmt.Println("Usage: gud merge [--no-verify] [<base>] <target> | gud merge --abort")
 		}
-		mergeBranches(os.Args[2], os.Args[3])
 	case "rebase":
 		if len(os.Args) != 4 {
 			fmt.Println("Usage: gud rebase <base> <target>")
@@ -113,15 +150,35 @@ func main() {
 		}
 		rebaseOnto(os.Args[2], os.Args[3])
 	case "push":
-		pushRemote()
+		pushRemote(os.Args[2:])
 	case "pull":
-		pullRemote()
+		pullRemote(os.Args[2:])
+	case "fetch":
+		fetchCommand(os.Args[2:])
+	case "remote":
+		handleRemoteCommand(os.Args[2:])
+	case "serve":
+		serveCommand(os.Args[2:])
+	case "upload-pack":
+		uploadPackCommand(os.Args[2:])
+	case "receive-pack":
+		receivePackCommand(os.Args[2:])
 	case "log":
-		if len(os.Args) == 3 {
-			showFileHistory(os.Args[2])
-		} else {
-			logHistory()
-		}
+		logCommand(os.Args[2:])
+	case "show":
+		showCommand(os.Args[2:])
+	case "blame":
+		blameCommand(os.Args[2:])
+	case "format-patch":
+		formatPatchCommand(os.Args[2:])
+	case "apply":
+		applyCommand(os.Args[2:])
+	case "am":
+		amCommand(os.Args[2:])
+	case "verify-commit":
+		verifyCommitCommand(os.Args[2:])
+	case "verify-tag":
+		verifyTagCommand(os.Args[2:])
 	case "tag":
 		handleTagCommand(os.Args[2:])
 	case "get-tag":
@@ -137,28 +194,39 @@ func main() {
 			showRemoteURL()
 		}
 	case "clone":
-		if len(os.Args) != 4 {
-			fmt.Println("Usage: gud clone <remote_path> <target_dir>")
-			return
-		}
-		cloneRepository(os.Args[2], os.Args[3])
+		handleCloneCommand(os.Args[2:])
 	case "revert":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: gud revert <commit-id>")
-			return
-		}
-		revertTo(os.Args[2])
+		revertCommand(os.Args[2:])
+	case "cherry-pick":
+		cherryPickCommand(os.Args[2:])
 	case "config":
-		if len(os.Args) < 4 {
-			fmt.Println("Usage: gud config <username> <email>")
-			return
-		}
-		saveUserConfig(os.Args[2], os.Args[3])
+		handleConfigCommand(os.Args[2:])
+	case "repack":
+		repackCommand(os.Args[2:])
+	case "gc":
+		gcCommand(os.Args[2:])
+	case "prune":
+		pruneCommand(os.Args[2:])
+	case "fsck":
+		fsckCommand(os.Args[2:])
+	case "stash":
+		handleStashCommand(os.Args[2:])
+	case "reflog":
+		handleReflogCommand(os.Args[2:])
+	case "reset":
+		resetCommand(os.Args[2:])
 	default:
 		fmt.Println("Unknown command:", cmd)
 	}
 }
 
+func optionalArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
 func readIgnorePatterns() map[string]bool {
 	patterns := make(map[string]bool)
 	file, err := os.Open(IGNORE_FILE)
@@ -175,144 +243,43 @@ func readIgnorePatterns() map[string]bool {
 /* ----------------------------------------
    FEATURE 1: Undo Last Commit (Amend)
 -------------------------------------------*/
-func amendLastCommit(newMsg string) {
+func amendLastCommit(newMsg string, verify bool) {
 	last := latestCommit(currentBranch())
 	if last == nil {
 		fmt.Println("No commits to amend.")
 		return
 	}
-
-	// Load staged files (if any) to update commit snapshot
-	staged := loadStaging()
-	if len(staged) > 0 {
-		last.Files = staged
-		os.Remove(STAGING_FILE)
-	}
-
-	last.Message = newMsg
-	last.Timestamp = time.Now().Format(time.RFC3339)
-
-	data, err := json.MarshalIndent(last, "", "  ")
-	if err != nil {
-		fmt.Println("Error encoding commit:", err)
-		return
-	}
-	err = os.WriteFile(filepath.Join(COMMITS_DIR, last.ID+".json"), data, 0644)
-	if err != nil {
-		fmt.Println("Error writing commit file:", err)
-		return
-	}
-
-	// Update log (append amend note)
-	appendLog(fmt.Sprintf("%s [%s] (amended) %s\n", last.ID, last.Branch, newMsg))
-	fmt.Println("Amended commit:", last.ID)
-}
-
-/* ----------------------------------------
-   FEATURE 2: Show Commit History With Pretty Graph
--------------------------------------------*/
-func logHistory() {
-	data, err := os.ReadFile(LOG_FILE)
-	if err != nil {
-		fmt.Println("No commits yet.")
-		return
-	}
-
-	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
-	if len(lines) == 0 {
-		fmt.Println("No commits yet.")
+	newMsg, ok := prepareCommit(newMsg, "commit", verify)
+	if !ok {
 		return
 	}
 
-	// Simple pretty print with branch and commit IDs
-	fmt.Println("Commit history:")
-	for i, line := range lines {
-		// Format: ID [branch] message
-		// Example: 1234567890 [main] Initial commit
-		parts := strings.SplitN(line, " ", 3)
-		if len(parts) < 3 {
-			fmt.Println(line)
-			continue
-		}
-		id := parts[0]
-		branch := strings.Trim(parts[1], "[]")
-		msg := parts[2]
-
-		indent := strings.Repeat("| ", i)
-		fmt.Printf("%s* %s (%s) %s\n", indent, id[:7], branch, msg)
-	}
-}
-
-/* ----------------------------------------
-   FEATURE 3: Tag commits (create/list/delete)
--------------------------------------------*/
-func handleTagCommand(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: gud tag <create|list|delete> [args]")
-		return
-	}
-	switch args[0] {
-	case "create":
-		if len(args) != 3 {
-			fmt.Println("Usage: gud tag create <name> <commit_id>")
-			return
-		}
-		tagCommit(args[1], args[2])
-	case "list":
-		listTags()
-	case "delete":
-		if len(args) != 2 {
-			fmt.Println("Usage: gud tag delete <name>")
-			return
-		}
-		deleteTag(args[1])
-	default:
-		fmt.Println("Unknown tag command:", args[0])
+	// Staged changes (if any) are folded into the replacement commit. The
+	// old commit is left behind for gc.
+	files := make(map[string]string)
+	for k, v := range last.Files {
+		files[k] = v
 	}
-}
-
-func tagCommit(tag, commitID string) {
-	tags := loadTags()
-	tags[tag] = commitID
-	saveTags(tags)
-	fmt.Printf("Tagged commit %s as '%s'\n", commitID, tag)
-}
-
-func listTags() {
-	tags := loadTags()
-	if len(tags) == 0 {
-		fmt.Println("No tags found.")
-		return
+	for k, v := range loadStaging() {
+		files[k] = v
 	}
-	fmt.Println("Tags:")
-	for tag, commit := range tags {
-		fmt.Printf(" - %s: %s\n", tag, commit)
+	for _, k := range loadStagedRemovals() {
+		delete(files, k)
 	}
-}
 
-func deleteTag(tag string) {
-	tags := loadTags()
-	if _, ok := tags[tag]; !ok {
-		fmt.Println("Tag not found:", tag)
+	c, err := commitTree(files, newMsg, last.Parents, last.Author, configBool("commit.gpgSign", false))
+	if err != nil {
+		fmt.Println("Error writing commit:", err)
 		return
 	}
-	delete(tags, tag)
-	saveTags(tags)
-	fmt.Println("Deleted tag:", tag)
-}
-
-func loadTags() map[string]string {
-	tags := make(map[string]string)
-	data, err := os.ReadFile(TAGS_FILE)
-	if err == nil {
-		json.Unmarshal(data, &tags)
-	}
-	return tags
-}
+	updateBranch(currentBranch(), c.ID, "commit (amend): "+subjectLine(newMsg))
+	os.Remove(STAGING_FILE)
+	os.Remove(STAGING_REMOVED_FILE)
 
-func saveTags(tags map[string]string) {
-	data, _ := json.MarshalIndent(tags, "", "  ")
-	os.WriteFile(TAGS_FILE, data, 0644)
+	// Update log (append amend note)
+	appendLog(fmt.Sprintf("%s [%s] (amended) %s\n", c.ID, c.Branch, subjectLine(newMsg)))
+	fmt.Println("Amended commit:", c.ID)
+	runHook("post-commit")
 }
 
 /* ----------------------------------------
@@ -320,6 +287,18 @@ func saveTags(tags map[string]string) {
 -------------------------------------------*/
 func unstageFile(file string) {
 	staged := loadStaging()
+	removed := loadStagedRemovals()
+	if containsString(removed, file) {
+		var kept []string
+		for _, p := range removed {
+			if p != file {
+				kept = append(kept, p)
+			}
+		}
+		saveStagedRemovals(kept)
+		fmt.Println("Unstaged:", file)
+		return
+	}
 	if _, ok := staged[file]; !ok {
 		fmt.Println("File is not staged:", file)
 		return
@@ -377,67 +356,22 @@ func interactiveAdd(file string) {
 	fmt.Println("Interactive add done for", file)
 }
 
-/* ----------------------------------------
-   FEATURE 6: Show file history (file-specific commit log)
--------------------------------------------*/
-func showFileHistory(filename string) {
-	entries, err := ioutil.ReadDir(COMMITS_DIR)
-	if err != nil {
-		fmt.Println("Error reading commits:", err)
-		return
-	}
-
-	type fileCommit struct {
-		CommitID  string
-		Timestamp string
-		Message   string
-	}
-
-	var history []fileCommit
-
-	for _, entry := range entries {
-		data, err := os.ReadFile(filepath.Join(COMMITS_DIR, entry.Name()))
-		if err != nil {
-			continue
-		}
-		var c Commit
-		json.Unmarshal(data, &c)
-		if _, ok := c.Files[filename]; ok {
-			history = append(history, fileCommit{c.ID, c.Timestamp, c.Message})
-		}
-	}
-
-	if len(history) == 0 {
-		fmt.Println("No history for file:", filename)
-		return
-	}
-
-	// Print history
-	fmt.Printf("History for file: %s\n", filename)
-	for i := len(history) - 1; i >= 0; i-- {
-		c := history[i]
-		fmt.Printf("- %s (%s): %s\n", c.CommitID[:7], c.Timestamp, c.Message)
-	}
-}
-
 /* ----------------------------------------
    FEATURE 7: Checkout specific file from commit/tag
 -------------------------------------------*/
 func checkoutFile(commitOrTag, file string) {
-	commitID := commitOrTag
-	tags := loadTags()
-	if cid, ok := tags[commitOrTag]; ok {
-		commitID = cid
+	commitID, err := resolveRevision(commitOrTag)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
 	}
 
-	data, err := os.ReadFile(filepath.Join(COMMITS_DIR, commitID+".json"))
+	c, err := readCommit(commitID)
 	if err != nil {
 		fmt.Println("Commit not found:", commitID)
 		return
 	}
 
-	var c Commit
-	json.Unmarshal(data, &c)
 	content, ok := c.Files[file]
 	if !ok {
 		fmt.Println("File not found in commit:", file)
@@ -450,20 +384,61 @@ func checkoutFile(commitOrTag, file string) {
 		return
 	}
 	fmt.Printf("Checked out %s from %s\n", file, commitOrTag)
+	head := hookID(currentBranchHead())
+	runHook("post-checkout", head, head, "0")
 }
 
-/* ----------------------------------------
-   FEATURE 8: List remote commits before pull (preview remote changes)
--------------------------------------------*/
-func previewRemoteCommits() {
-	localLatest := latestCommit(currentBranch())
-	remoteCommits := loadRemoteCommits()
-	fmt.Println("Remote commits not in local:")
-
-	for _, c := range remoteCommits {
-		if localLatest == nil || c.Timestamp > localLatest.Timestamp {
-			fmt.Printf("- %s: %s\n", c.ID[:7], c.Message)
+// checkoutCommand handles "<branch>", "-b <new-branch> [<start>]" and
+// "[<revision>] -- <file>...".
+func checkoutCommand(args []string) {
+	for i, a := range args {
+		if a != "--" {
+			continue
+		}
+		if i > 1 || len(args) == i+1 {
+			break
+		}
+		rev := "HEAD"
+		if i == 1 {
+			rev = args[0]
+		}
+		for _, file := range args[i+1:] {
+			checkoutFile(rev, file)
+		}
+		return
+	}
+	switch {
+	case len(args) == 1 && args[0] == currentBranch():
+		fmt.Printf("Already on '%s'\n", args[0])
+	case len(args) == 1 && args[0] != "-b":
+		checkoutBranch(args[0])
+	case (len(args) == 2 || len(args) == 3) && args[0] == "-b":
+		name := args[1]
+		if !validBranchName(name) {
+			fmt.Println("Invalid branch name:", name)
+			return
 		}
+		branches := loadBranches()
+		if _, exists := branches[name]; exists {
+			fmt.Println("Branch already exists:", name)
+			return
+		}
+		start, from := currentBranchHead(), "HEAD"
+		if len(args) == 3 {
+			from = args[2]
+			id, err := resolveRevision(args[2])
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			start = id
+		}
+		updateBranch(name, start, "branch: Created from "+from)
+		if !checkoutBranch(name) {
+			saveBranches(branches)
+		}
+	default:
+		fmt.Println("Usage: gud checkout <branch> | gud checkout -b <new-branch> [<start>] | gud checkout [<revision>] -- <file>...")
 	}
 }
 
@@ -472,7 +447,7 @@ func previewRemoteCommits() {
 -------------------------------------------*/
 func handleBranchCommand(args []string) {
 	if len(args) == 0 {
-		fmt.Println("Usage: gud branch <create|list|delete> [args]")
+		fmt.Println("Usage: gud branch <create|list|delete|set-upstream|unset-upstream> [args]")
 		return
 	}
 	switch args[0] {
@@ -483,7 +458,22 @@ func handleBranchCommand(args []string) {
 		}
 		createBranch(args[1])
 	case "list":
+		if len(args) == 2 && (args[1] == "-r" || args[1] == "--remotes") {
+			listRemoteBranches()
+			return
+		}
 		listBranches()
+		if len(args) == 2 && (args[1] == "-a" || args[1] == "--all") {
+			listRemoteBranches()
+		}
+	case "set-upstream":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Println("Usage: gud branch set-upstream <remote>/<branch> [branch]")
+			return
+		}
+		setUpstreamCommand(args[1], optionalArg(args[2:]))
+	case "unset-upstream":
+		unsetUpstreamCommand(optionalArg(args[1:]))
 	case "delete":
 		if len(args) != 2 {
 			fmt.Println("Usage: gud branch delete <name>")
@@ -495,14 +485,22 @@ func handleBranchCommand(args []string) {
 	}
 }
 
+// validBranchName applies the ref name rules; HEAD is reserved.
+func validBranchName(name string) bool {
+	return validRefName(name) && name != "HEAD"
+}
+
 func createBranch(name string) {
+	if !validBranchName(name) {
+		fmt.Println("Invalid branch name:", name)
+		return
+	}
 	branches := loadBranches()
 	if _, ok := branches[name]; ok {
 		fmt.Println("Branch already exists:", name)
 		return
 	}
-	branches[name] = currentBranchHead()
-	saveBranches(branches)
+	updateBranch(name, currentBranchHead(), "branch: Created from HEAD")
 	fmt.Println("Created branch:", name)
 }
 
@@ -528,8 +526,10 @@ func deleteBranch(name string) {
 		fmt.Println("Cannot delete current branch.")
 		return
 	}
+	old := branches[name]
 	delete(branches, name)
 	saveBranches(branches)
+	appendReflogAt(GUD_DIR, "refs/heads/"+name, old, "", "branch: deleted")
 	fmt.Println("Deleted branch:", name)
 }
 
@@ -586,6 +586,7 @@ func initRepo() {
 	os.Mkdir(GUD_DIR, 0755)
 	os.Mkdir(BRANCHES_DIR, 0755)
 	os.Mkdir(COMMITS_DIR, 0755)
+	os.Mkdir(HOOKS_DIR, 0755)
 	os.WriteFile(CURRENT_BRANCH, []byte("main"), 0644)
 	os.WriteFile(STAGING_FILE, []byte("{}"), 0644)
 	os.WriteFile(TAGS_FILE, []byte("{}"), 0644)
@@ -593,6 +594,23 @@ func initRepo() {
 	fmt.Println("Initialized empty gud repository")
 }
 
+// initBareRepo makes dir an empty bare repository, one without a working
+// tree that is pushed to and fetched from.
+func initBareRepo(dir string) {
+	if isBareRepoAt(dir) {
+		fmt.Println("Error: already a bare repository:", dir)
+		return
+	}
+	if err := os.MkdirAll(commitsDirAt(dir), 0755); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	saveBranchesAt(dir, map[string]string{})
+	saveTagsAt(dir, map[string]string{})
+	os.WriteFile(filepath.Join(dir, "HEAD"), []byte("main"), 0644)
+	fmt.Println("Initialized empty bare gud repository in", dir)
+}
+
 func addFileToStaging(file string) {
 	patterns := loadIgnorePatterns()
 	if isIgnored(file, patterns) {
@@ -601,15 +619,42 @@ func addFileToStaging(file string) {
 	}
 	content, err := os.ReadFile(file)
 	if err != nil {
+		// A tracked file that is gone from the working tree is staged for
+		// removal, so that moves can be recorded.
+		if _, tracked := indexFiles(getLastCommitFiles())[file]; tracked && os.IsNotExist(err) {
+			stageRemoval(file)
+			fmt.Println("Staged removal:", file)
+			return
+		}
 		fmt.Println("File not found:", file)
 		return
 	}
 	staged := loadStaging()
 	staged[file] = string(content)
 	saveStaging(staged)
+	if removed := loadStagedRemovals(); containsString(removed, file) {
+		var kept []string
+		for _, p := range removed {
+			if p != file {
+				kept = append(kept, p)
+			}
+		}
+		saveStagedRemovals(kept)
+	}
 	fmt.Println("Added to staging:", file)
 }
 
+// stageRemoval records that a tracked file is to be deleted by the next
+// commit.
+func stageRemoval(file string) {
+	staged := loadStaging()
+	delete(staged, file)
+	saveStaging(staged)
+	if _, inHead := getLastCommitFiles()[file]; inHead && !containsString(loadStagedRemovals(), file) {
+		saveStagedRemovals(append(loadStagedRemovals(), file))
+	}
+}
+
 func loadStaging() map[string]string {
 	data, err := os.ReadFile(STAGING_FILE)
 	if err != nil {
@@ -628,15 +673,78 @@ func saveStaging(staging map[string]string) {
 	os.WriteFile(STAGING_FILE, data, 0644)
 }
 
-func createCommit(msg string) {
+// loadStagedRemovals returns the tracked paths staged for deletion.
+func loadStagedRemovals() []string {
+	var removed []string
+	data, err := os.ReadFile(STAGING_REMOVED_FILE)
+	if err == nil {
+		json.Unmarshal(data, &removed)
+	}
+	return removed
+}
+
+func saveStagedRemovals(removed []string) {
+	if len(removed) == 0 {
+		os.Remove(STAGING_REMOVED_FILE)
+		return
+	}
+	sort.Strings(removed)
+	data, _ := json.MarshalIndent(removed, "", "  ")
+	os.WriteFile(STAGING_REMOVED_FILE, data, 0644)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// createCommit commits the staging area. An empty author means the current
+// user, or the original author of a cherry-pick that stopped on a conflict.
+// An empty date means now.
+func createCommit(msg, author, date string, sign, verify bool) {
 	staged := loadStaging()
-	if len(staged) == 0 {
+	removed := loadStagedRemovals()
+	merging := mergeInProgress()
+	if len(staged) == 0 && len(removed) == 0 && !merging {
 		fmt.Println("Nothing to commit.")
 		return
 	}
 
+	var unresolved []string
+	for _, p := range loadMergeConflicts() {
+		if _, ok := staged[p]; !ok && !containsString(removed, p) {
+			unresolved = append(unresolved, p)
+		}
+	}
+	if len(unresolved) > 0 {
+		fmt.Println("Cannot commit: unresolved merge conflicts in", strings.Join(unresolved, ", "))
+		return
+	}
+
+	var parents []string
+	if merging {
+		mergeHead, _ := os.ReadFile(MERGE_HEAD_FILE)
+		parents = append(parents, strings.TrimSpace(string(mergeHead)))
+	}
+
+	source := "message"
+	if merging {
+		source = "merge"
+	}
+	msg, ok := prepareCommit(msg, source, verify)
+	if !ok {
+		return
+	}
+
 	branch := currentBranch()
 	last := latestCommit(branch)
+	if last != nil {
+		parents = append([]string{last.ID}, parents...)
+	}
 
 	files := make(map[string]string)
 	if last != nil {
@@ -648,28 +756,101 @@ func createCommit(msg string) {
 	for k, v := range staged {
 		files[k] = v
 	}
+	for _, k := range removed {
+		delete(files, k)
+	}
 
-	id := fmt.Sprintf("%x", time.Now().UnixNano())
-	c := Commit{
-		ID:        id,
-		Message:   msg,
-		Timestamp: time.Now().Format(time.RFC3339),
-		Files:     files,
-		Branch:    branch,
+	if data, err := os.ReadFile(CHERRY_PICK_HEAD_FILE); err == nil && author == "" {
+		if picked, err := readCommit(strings.TrimSpace(string(data))); err == nil {
+			author = picked.Author
+		}
+	}
+	c, err := commitTreeDated(files, msg, parents, author, date, sign)
+	if err != nil {
+		fmt.Println("Error writing commit:", err)
+		return
 	}
-	data, _ := json.MarshalIndent(c, "", "  ")
-	os.WriteFile(filepath.Join(COMMITS_DIR, id+".json"), data, 0644)
+	id := c.ID
 
 	// update branch head
-	branches := loadBranches()
-	branches[branch] = id
-	saveBranches(branches)
+	reason := "commit: "
+	if merging {
+		reason = "commit (merge): "
+	} else if last == nil {
+		reason = "commit (initial): "
+	}
+	updateBranch(branch, id, reason+subjectLine(msg))
 
 	// clear staging
 	os.Remove(STAGING_FILE)
+	os.Remove(STAGING_REMOVED_FILE)
+	clearMergeState()
+	if pickStopped() {
+		finishStoppedPick()
+	}
 
-	appendLog(fmt.Sprintf("%s [%s] %s\n", id, branch, msg))
+	appendLog(fmt.Sprintf("%s [%s] %s\n", id, branch, subjectLine(msg)))
 	fmt.Println("Committed:", id)
+	runHook("post-commit")
+}
+
+// commitMessage builds a message from "-m <msg>" flags (repeatable) or the
+// remaining arguments, and falls back to core.editor when neither is given.
+func commitMessage(args []string) string {
+	var paragraphs, words []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-m" && i+1 < len(args) {
+			paragraphs = append(paragraphs, args[i+1])
+			i++
+			continue
+		}
+		words = append(words, args[i])
+	}
+	if len(paragraphs) > 0 {
+		return strings.Join(paragraphs, "\n\n")
+	}
+	if len(words) > 0 {
+		return strings.Join(words, " ")
+	}
+	return editMessage("\n# Enter the commit message. Lines starting with '#' are ignored.\n")
+}
+
+// editMessage opens the configured editor on a template and returns the
+// text the user saved, without comment lines.
+func editMessage(template string) string {
+	editor := configString("core.editor", "")
+	for _, env := range []string{"GUD_EDITOR", "VISUAL", "EDITOR"} {
+		if editor != "" {
+			break
+		}
+		editor = os.Getenv(env)
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	path := filepath.Join(GUD_DIR, "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+		fmt.Println("Error writing message file:", err)
+		return ""
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Println("Editor failed:", err)
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var lines []string
+	for _, l := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(l, "#") {
+			lines = append(lines, strings.TrimRight(l, " \t\r"))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
 }
 
 func latestCommit(branch string) *Commit {
@@ -678,17 +859,23 @@ func latestCommit(branch string) *Commit {
 	if !ok {
 		return nil
 	}
-	data, err := os.ReadFile(filepath.Join(COMMITS_DIR, head+".json"))
+	c, err := readCommit(head)
 	if err != nil {
 		return nil
 	}
-	var c Commit
-	json.Unmarshal(data, &c)
-	return &c
+	return c
 }
 
 func loadBranches() map[string]string {
-	data, err := os.ReadFile(BRANCHES_DIR + "/branches.json")
+	return loadBranchesAt(GUD_DIR)
+}
+
+func saveBranches(branches map[string]string) {
+	saveBranchesAt(GUD_DIR, branches)
+}
+
+func loadBranchesAt(gudDir string) map[string]string {
+	data, err := os.ReadFile(filepath.Join(gudDir, "branches", "branches.json"))
 	if err != nil {
 		return make(map[string]string)
 	}
@@ -700,10 +887,10 @@ func loadBranches() map[string]string {
 	return branches
 }
 
-func saveBranches(branches map[string]string) {
-	os.MkdirAll(BRANCHES_DIR, 0755)
+func saveBranchesAt(gudDir string, branches map[string]string) {
+	os.MkdirAll(filepath.Join(gudDir, "branches"), 0755)
 	data, _ := json.MarshalIndent(branches, "", "  ")
-	os.WriteFile(BRANCHES_DIR+"/branches.json", data, 0644)
+	os.WriteFile(filepath.Join(gudDir, "branches", "branches.json"), data, 0644)
 }
 
 func currentBranch() string {
@@ -729,13 +916,11 @@ func appendLog(line string) {
 }
 
 func restoreCommit(commitID string) {
-	data, err := os.ReadFile(filepath.Join(COMMITS_DIR, commitID+".json"))
+	c, err := readCommit(commitID)
 	if err != nil {
 		fmt.Println("Commit not found:", commitID)
 		return
 	}
-	var c Commit
-	json.Unmarshal(data, &c)
 
 	for file, content := range c.Files {
 		os.WriteFile(file, []byte(content), 0644)
@@ -743,224 +928,252 @@ func restoreCommit(commitID string) {
 	fmt.Println("Restored commit:", commitID)
 }
 
-func loadRemoteCommits() []Commit {
-	var commits []Commit
-	files, err := ioutil.ReadDir(REMOTE_DIR + "/commits")
-	if err != nil {
-		return commits
-	}
-	for _, f := range files {
-		data, err := os.ReadFile(filepath.Join(REMOTE_DIR, "commits", f.Name()))
-		if err != nil {
-			continue
-		}
-		var c Commit
-		json.Unmarshal(data, &c)
-		commits = append(commits, c)
-	}
-	return commits
-}
-
-/* ----------------------------------------
- User config
--------------------------------------------*/
-func saveUserConfig(username, email string) {
-	cfg := Config{username, email}
-	data, _ := json.MarshalIndent(cfg, "", "  ")
-	os.WriteFile(CONFIG_FILE, data, 0644)
-	fmt.Println("User config saved.")
+func switchBranch(branch string) {
+	from, old := currentBranch(), currentBranchHead()
+	os.WriteFile(CURRENT_BRANCH_FILE, []byte(branch), 0644)
+	appendReflogAt(GUD_DIR, "HEAD", old, currentBranchHead(), fmt.Sprintf("checkout: moving from %s to %s", from, branch))
+	fmt.Println("Switched to branch:", branch)
 }
 
-func loadUserConfig() *Config {
-	data, err := os.ReadFile(CONFIG_FILE)
-	if err != nil {
-		return nil
-	}
-	var cfg Config
-	json.Unmarshal(data, &cfg)
-	return &cfg
-}
+func mergeBranches(base, target string, verify bool) {
+	fmt.Printf("Merging branch '%s' into '%s'\n", target, base)
 
-func pushRemote() {
-	remoteCommitsDir := filepath.Join(REMOTE_DIR, "commits")
-	err := os.MkdirAll(remoteCommitsDir, 0755)
-	if err != nil {
-		fmt.Println("Failed to create remote commits directory:", err)
+	latestTarget := latestCommit(target)
+	if latestTarget == nil {
+		fmt.Println("No commits found on target branch:", target)
 		return
 	}
 
-	entries, err := ioutil.ReadDir(COMMITS_DIR)
-	if err != nil {
-		fmt.Println("Error reading commits directory:", err)
+	// Switch to base branch and merge the target's head into it
+	if !checkoutBranch(base) {
 		return
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		src := filepath.Join(COMMITS_DIR, entry.Name())
-		dst := filepath.Join(remoteCommitsDir, entry.Name())
-
-		data, err := os.ReadFile(src)
-		if err != nil {
-			fmt.Println("Error reading commit file:", src, err)
-			continue
-		}
-
-		err = os.WriteFile(dst, data, 0644)
-		if err != nil {
-			fmt.Println("Error writing to remote commit file:", dst, err)
-		}
+	message := fmt.Sprintf("Merge branch '%s' into '%s'", target, base)
+	before := currentBranchHead()
+	if integrateCommit(latestTarget.ID, target, message, false, false, verify) && currentBranchHead() != before {
+		fmt.Println("Merge completed.")
+		runHook("post-merge", "0")
 	}
-	fmt.Println("Pushed commits to remote.")
 }
 
+func rebaseOnto(base, target string) {
+	fmt.Printf("Rebasing branch '%s' onto '%s'\n", target, base)
 
-func pullRemote() {
-	remoteCommitsDir := filepath.Join(REMOTE_DIR, "commits")
-
-	entries, err := ioutil.ReadDir(remoteCommitsDir)
-	if err != nil {
-		fmt.Println("Error reading remote commits directory:", err)
+	latestBase := latestCommit(base)
+	if latestBase == nil {
+		fmt.Println("No commits found on base branch:", base)
 		return
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		src := filepath.Join(remoteCommitsDir, entry.Name())
-		dst := filepath.Join(COMMITS_DIR, entry.Name())
-
-		if _, err := os.Stat(dst); err == nil {
-			fmt.Printf("Commit %s already exists locally, skipping.\n", entry.Name())
-			continue
-		}
-
-		data, err := os.ReadFile(src)
-		if err != nil {
-			fmt.Println("Error reading remote commit file:", src, err)
-			continue
-		}
-
-		err = os.WriteFile(dst, data, 0644)
-		if err != nil {
-			fmt.Println("Error writing to local commit file:", dst, err)
-		}
+	// Switch to the target branch and replay its commits onto base
+	if !checkoutBranch(target) {
+		return
+	}
+	if integrateCommit(latestBase.ID, base, "", true, false, true) {
+		fmt.Println("Rebase completed.")
 	}
-	fmt.Println("Pulled commits from remote.")
 }
 
-func switchBranch(branch string) {
-	os.WriteFile(CURRENT_BRANCH_FILE, []byte(branch), 0644)
-	fmt.Println("Switched to branch:", branch)
+type cloneOptions struct {
+	Branch     string
+	Bare       bool
+	Depth      int
+	NoCheckout bool
 }
 
-func mergeBranches(base, target string) {
-	fmt.Printf("Merging branch '%s' into '%s'\n", target, base)
-
-	latestTarget := latestCommit(target)
-	if latestTarget == nil {
-		fmt.Println("No commits found on target branch:", target)
+func handleCloneCommand(args []string) {
+	usage := "Usage: gud clone [--branch <name>] [--bare] [--depth <n>] [--no-checkout] <remote> [<target_dir>]"
+	var opts cloneOptions
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case (a == "--branch" || a == "-b") && i+1 < len(args):
+			opts.Branch = args[i+1]
+			i++
+		case strings.HasPrefix(a, "--branch="):
+			opts.Branch = strings.TrimPrefix(a, "--branch=")
+		case a == "--bare":
+			opts.Bare = true
+		case a == "--depth" && i+1 < len(args):
+			fmt.Sscanf(args[i+1], "%d", &opts.Depth)
+			i++
+		case strings.HasPrefix(a, "--depth="):
+			fmt.Sscanf(strings.TrimPrefix(a, "--depth="), "%d", &opts.Depth)
+		case a == "--no-checkout" || a == "-n":
+			opts.NoCheckout = true
+		default:
+			positional = append(positional, a)
+		}
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		fmt.Println(usage)
 		return
 	}
-
-	// Restore target commit files
-	for path, content := range latestTarget.Files {
-		err := os.WriteFile(path, []byte(content), 0644)
-		if err != nil {
-			fmt.Println("Error writing file during merge:", path, err)
-			return
-		}
+	target := ""
+	if len(positional) == 2 {
+		target = positional[1]
+	} else {
+		target = cloneDirName(positional[0], opts.Bare)
 	}
-
-	// Switch to base branch and commit the merge
-	switchBranch(base)
-
-	message := fmt.Sprintf("Merge branch '%s' into '%s'", target, base)
-	createCommit(message)
-
-	fmt.Println("Merge completed.")
+	cloneRepository(positional[0], target, opts)
 }
 
-
-func rebaseOnto(base, target string) {
-	fmt.Printf("Rebasing branch '%s' onto '%s'\n", target, base)
-
-	// For simplicity, reuse merge logic to simulate rebase
-	mergeBranches(base, target)
-
-	// Switch back to target branch
-	switchBranch(target)
-
-	fmt.Println("Rebase completed.")
+// cloneDirName derives a directory name from the last component of a URL.
+func cloneDirName(url string, bare bool) string {
+	name := strings.TrimRight(url, "/")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, GUD_DIR)
+	if name == "" {
+		name = "repo"
+	}
+	if bare {
+		name += GUD_DIR
+	}
+	return name
 }
 
-
-func cloneRepository(remotePath, targetDir string) {
-	err := os.MkdirAll(targetDir, 0755)
+func cloneRepository(remotePath, targetDir string, opts cloneOptions) {
+	url := remotePath
+	if _, _, _, isSSH := parseSSHURL(url); !isSSH && !strings.Contains(url, "://") {
+		if abs, err := filepath.Abs(url); err == nil {
+			url = abs
+		}
+	}
+	if entries, err := os.ReadDir(targetDir); err == nil && len(entries) > 0 {
+		fmt.Println("Target directory already exists and is not empty:", targetDir)
+		return
+	}
+	t, err := openTransport(url, false)
 	if err != nil {
-		fmt.Println("Failed to create target directory:", err)
+		fmt.Println("Error:", err)
 		return
 	}
+	defer t.close()
 
-	copyDir := func(src, dst string) error {
-		return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			relPath, _ := filepath.Rel(src, path)
-			targetPath := filepath.Join(dst, relPath)
+	adv, err := t.listRefs()
+	if err != nil {
+		fmt.Println("Error reading remote refs:", err)
+		return
+	}
 
-			if info.IsDir() {
-				return os.MkdirAll(targetPath, info.Mode())
-			}
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return err
-			}
-			return os.WriteFile(targetPath, data, info.Mode())
-		})
+	// Tags come along unless the history is cut short by --depth.
+	remoteBranches := make(map[string]string)
+	remoteTags := make(map[string]string)
+	var wants []string
+	for ref, id := range adv.Refs {
+		if strings.HasPrefix(ref, "refs/heads/") {
+			remoteBranches[strings.TrimPrefix(ref, "refs/heads/")] = id
+			wants = append(wants, id)
+		}
+		if strings.HasPrefix(ref, "refs/tags/") && opts.Depth == 0 {
+			remoteTags[strings.TrimPrefix(ref, "refs/tags/")] = id
+			wants = append(wants, id)
+		}
 	}
+	sort.Strings(wants)
 
-	remoteGudDir := filepath.Join(remotePath, ".gud")
-	targetGudDir := filepath.Join(targetDir, ".gud")
+	branch := opts.Branch
+	if branch == "" {
+		branch = adv.Head
+	}
+	if _, ok := remoteBranches[branch]; !ok {
+		if opts.Branch != "" {
+			fmt.Println("Remote branch not found:", opts.Branch)
+			return
+		}
+		branch = "main"
+	}
 
-	err = copyDir(remoteGudDir, targetGudDir)
+	fmt.Printf("Cloning into '%s'...\n", targetDir)
+	absTarget, _ := filepath.Abs(targetDir)
+	_, statErr := os.Stat(targetDir)
+	created := os.IsNotExist(statErr)
+	err = os.MkdirAll(targetDir, 0755)
 	if err != nil {
-		fmt.Println("Error copying repository:", err)
+		fmt.Println("Failed to create target directory:", err)
 		return
 	}
+	// A failed clone leaves nothing behind, so that it can be retried: the
+	// directory goes if clone created it, otherwise what was put in it.
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		if created {
+			os.RemoveAll(absTarget)
+			return
+		}
+		entries, _ := os.ReadDir(absTarget)
+		for _, e := range entries {
+			os.RemoveAll(filepath.Join(absTarget, e.Name()))
+		}
+	}()
 
-	fmt.Println("Repository cloned to", targetDir)
-}
+	// A bare clone keeps the repository data directly in targetDir and
+	// mirrors the remote branches as its own.
+	gudDir := filepath.Join(targetDir, GUD_DIR)
+	if opts.Bare {
+		gudDir = targetDir
+	}
+	os.MkdirAll(commitsDirAt(gudDir), 0755)
 
+	var received []string
+	if len(wants) > 0 {
+		pack, err := t.fetchObjects(wants, nil, opts.Depth)
+		if err != nil {
+			fmt.Println("Error fetching objects:", err)
+			return
+		}
+		if received, err = storePackAt(gudDir, pack); err != nil {
+			fmt.Println("Error storing objects:", err)
+			return
+		}
+	}
+	os.WriteFile(filepath.Join(gudDir, "HEAD"), []byte(branch), 0644)
+	saveTagsAt(gudDir, remoteTags)
+	saveConfigFile(filepath.Join(gudDir, filepath.Base(CONFIG_FILE)), map[string]string{"remote.origin.url": url})
 
-func revertTo(commitID string) {
-	commitPath := filepath.Join(COMMITS_DIR, commitID+".json")
-	data, err := os.ReadFile(commitPath)
-	if err != nil {
-		fmt.Println("Commit not found:", commitID)
+	if opts.Bare {
+		saveBranchesAt(gudDir, remoteBranches)
+		done = true
+		fmt.Println("Bare repository cloned to", targetDir)
 		return
 	}
 
-	var c Commit
-	err = json.Unmarshal(data, &c)
-	if err != nil {
-		fmt.Println("Error parsing commit:", err)
+	if err := os.Chdir(targetDir); err != nil {
+		fmt.Println("Failed to enter target directory:", err)
 		return
 	}
-
-	for file, content := range c.Files {
-		err := os.WriteFile(file, []byte(content), 0644)
-		if err != nil {
-			fmt.Println("Error restoring file:", file, err)
-			return
+	os.WriteFile(STAGING_FILE, []byte("{}"), 0644)
+	os.WriteFile(LOG_FILE, []byte(""), 0644)
+	if opts.Depth > 0 {
+		recordShallow(received)
+	}
+	saveRemoteRefs(DEFAULT_REMOTE, remoteBranches)
+
+	head, ok := remoteBranches[branch]
+	if ok {
+		saveBranches(map[string]string{branch: head})
+		logBranchUpdateAt(GUD_DIR, branch, "", head, "clone: from "+url)
+		setBranchUpstream(branch, DEFAULT_REMOTE, branch)
+		if !opts.NoCheckout {
+			if err := updateWorkingTree(map[string]string{}, commitFiles(head)); err != nil {
+				fmt.Println("Error checking out files:", err)
+				return
+			}
 		}
+	} else {
+		saveBranches(map[string]string{})
+		fmt.Println("Warning: you appear to have cloned an empty repository.")
 	}
 
-	fmt.Println("Reverted working directory to commit:", commitID)
+	done = true
+	fmt.Println("Repository cloned to", targetDir)
 }
 
 func getCommitByTag(tag string) {
@@ -970,58 +1183,31 @@ func getCommitByTag(tag string) {
 		fmt.Println("Tag not found")
 		return
 	}
-	restoreCommit(id)
+	restoreCommit(peelTag(id))
 }
 
 
-func diff() {
-	current := getWorkingFiles()
-	last := getLastCommitFiles()
-
-	fmt.Println("Differences:")
-	for file, currentContent := range current {
-		lastContent, exists := last[file]
-		if !exists {
-			fmt.Println("+", file) // New file
-		} else if currentContent != lastContent {
-			fmt.Println("~", file) // Modified file
-		}
-	}
-
-	for file := range last {
-		if _, exists := current[file]; !exists {
-			fmt.Println("-", file) // Deleted file
-		}
-	}
-}
-
 func getLastCommitFiles() map[string]string {
-	entries, _ := ioutil.ReadDir(COMMITS_DIR)
-	var last Commit
-	for _, entry := range entries {
-		data, _ := os.ReadFile(filepath.Join(COMMITS_DIR, entry.Name()))
-		var c Commit
-		json.Unmarshal(data, &c)
-		if last.Timestamp < c.Timestamp {
-			last = c
-		}
-	}
-	return last.Files
+	return commitFiles(currentBranchHead())
 }
 
 func getWorkingFiles() map[string]string {
 	files := make(map[string]string)
 	ignores := readIgnorePatterns()
-	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-	
 	var ignoresList []string
 	for key := range ignores {
-	    ignoresList = append(ignoresList, key)
-	}
-	// then call isIgnored with ignoresList
-	if strings.HasPrefix(path, ".trackly") || info.IsDir() || isIgnored(path, ignoresList) {
-	    return nil
+		ignoresList = append(ignoresList, key)
 	}
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path == GUD_DIR {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || isIgnored(path, ignoresList) {
+			return nil
+		}
 		content, _ := os.ReadFile(path)
 		files[path] = string(content)
 		return nil
@@ -1040,7 +1226,11 @@ func getStagedFiles() map[string]string {
 }
 
 func setRemoteURL(url string) {
-	os.WriteFile(REMOTE_URL_FILE, []byte(url), 0644)
+	scope, _ := configScopeByName("local")
+	if err := setConfigValue(scope, "remote.origin.url", url); err != nil {
+		fmt.Println("Error saving remote URL:", err)
+		return
+	}
 	fmt.Println("Remote URL set to:", url)
 }
 
@@ -1049,6 +1239,12 @@ func status() {
 	staged := getStagedFiles()
 	last := getLastCommitFiles()
 
+	fmt.Println("On branch", currentBranch())
+	if upstream := upstreamStatus(); upstream != "" {
+		fmt.Println(upstream)
+	}
+	fmt.Println()
+
 	fmt.Println("Modified files:")
 	for file, content := range current {
 		if lastContent, ok := last[file]; ok && content != lastContent && staged[file] != content {
@@ -1057,8 +1253,41 @@ func status() {
 	}
 
 	fmt.Println("\nStaged files:")
+	paired := make(map[string]bool)
+	for _, ch := range defaultRenameDetection().changes(last, indexFiles(last)) {
+		if ch.Status == 'R' || ch.Status == 'C' {
+			fmt.Println(" " + ch.String())
+			paired[ch.New] = true
+			paired[ch.Old] = ch.Status == 'R'
+		}
+	}
 	for file := range staged {
-		fmt.Println(" +", file)
+		if !paired[file] {
+			fmt.Println(" +", file)
+		}
+	}
+	for _, file := range loadStagedRemovals() {
+		if !paired[file] {
+			fmt.Println(" -", file)
+		}
+	}
+
+	unmerged := func() {
+		for _, file := range loadMergeConflicts() {
+			if _, ok := staged[file]; !ok {
+				fmt.Println(" !", file)
+			}
+		}
+	}
+	if mergeInProgress() {
+		fmt.Println("\nMerge in progress. Unmerged files:")
+		unmerged()
+	} else if seq, ok := loadSequencer(); ok {
+		fmt.Printf("\n%s in progress, %d commit(s) left; run 'gud %s --continue' or 'gud %s --abort'.\n", seq.Command, len(seq.Todo), seq.Command, seq.Command)
+		if pickStopped() {
+			fmt.Println("Unmerged files:")
+			unmerged()
+		}
 	}
 
 	fmt.Println("\nUntracked files:")
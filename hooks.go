@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ----------------------------------------
+   Hooks: scripts run around commits, checkouts, merges and pushes
+-------------------------------------------*/
+
+// Hooks are executable files in .gud/hooks/ named after the event. They run
+// in the top of the working tree (the repository directory for a bare
+// repository) with GUD_DIR set. A hook that is missing or not executable is
+// skipped.
+//
+//   pre-commit                       before a commit is made; non-zero aborts it
+//   prepare-commit-msg <file> <src>  may edit the message in <file>; <src> is
+//                                    "message", "merge" or "commit" (amend)
+//   commit-msg <file>                may edit or reject the final message
+//   post-commit                      after a commit
+//   post-checkout <old> <new> <flag> after checkout; flag is 1 for a branch
+//                                    checkout, 0 for a file checkout
+//   post-merge <squash>              after a successful merge (squash is 0)
+//   pre-push <remote> <url>          before a push; stdin has one line per ref:
+//                                    "<local ref> <local id> <remote ref> <remote id>"
+//   pre-receive                      in the receiving repository before any ref
+//                                    is updated; stdin has "<old> <new> <ref>" lines
+//   post-receive                     after the refs are updated, same stdin
+//
+// Non-zero exits of post-* hooks are ignored. --no-verify skips pre-commit,
+// commit-msg and pre-push.
+
+const (
+	HOOKS_DIR = ".gud/hooks"
+	ZERO_ID   = "0000000000000000000000000000000000000000"
+)
+
+// hookError reports a hook that exited non-zero.
+type hookError struct {
+	name string
+	err  error
+}
+
+func (e *hookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.name, e.err)
+}
+
+// runHookAt runs a hook of the repository at gudDir, feeding it stdin and
+// copying its output to out.
+func runHookAt(gudDir, name, stdin string, out io.Writer, args ...string) error {
+	path := filepath.Join(gudDir, "hooks", name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return nil
+	}
+	abs, err := filepath.Abs(gudDir)
+	if err != nil {
+		return err
+	}
+	dir := abs
+	if filepath.Base(abs) == filepath.Base(GUD_DIR) {
+		dir = filepath.Dir(abs)
+	}
+
+	cmd := exec.Command(filepath.Join(abs, "hooks", name), args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GUD_DIR="+abs)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return &hookError{name, err}
+	}
+	return nil
+}
+
+func runHook(name string, args ...string) error {
+	return runHookAt(GUD_DIR, name, "", os.Stdout, args...)
+}
+
+// commitMsgHooks passes a commit message through prepare-commit-msg and,
+// when verify is set, commit-msg. Comment lines are dropped afterwards.
+func commitMsgHooks(msg, source string, verify bool) (string, error) {
+	path := filepath.Join(GUD_DIR, "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte(msg+"\n"), 0644); err != nil {
+		return "", err
+	}
+	if err := runHook("prepare-commit-msg", path, source); err != nil {
+		return "", err
+	}
+	if verify {
+		if err := runHook("commit-msg", path); err != nil {
+			return "", err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, l := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(l, "#") {
+			lines = append(lines, strings.TrimRight(l, " \t\r"))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// prepareCommit runs the hooks that come before a commit and returns the
+// message to record. An empty message aborts the commit.
+func prepareCommit(msg, source string, verify bool) (string, bool) {
+	if verify {
+		if err := runHook("pre-commit"); err != nil {
+			fmt.Println("Aborting commit:", err)
+			return "", false
+		}
+	}
+	msg, err := commitMsgHooks(msg, source, verify)
+	if err != nil {
+		fmt.Println("Aborting commit:", err)
+		return "", false
+	}
+	if msg == "" {
+		fmt.Println("Aborting commit due to empty commit message.")
+		return "", false
+	}
+	return msg, true
+}
+
+// noVerifyFlag removes --no-verify (or -n) from args.
+func noVerifyFlag(args []string) ([]string, bool) {
+	var rest []string
+	verify := true
+	for _, a := range args {
+		if a == "--no-verify" || a == "-n" {
+			verify = false
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return rest, verify
+}
+
+// hookID passes an unborn ref to hooks as the all-zero ID.
+func hookID(id string) string {
+	if id == "" {
+		return ZERO_ID
+	}
+	return id
+}
+
+// receiveHookInput formats ref updates for pre-receive and post-receive.
+func receiveHookInput(olds map[string]string, updates []refUpdate) string {
+	var b strings.Builder
+	for _, u := range updates {
+		fmt.Fprintf(&b, "%s %s %s\n", hookID(olds[u.Ref]), hookID(u.New), u.Ref)
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* ----------------------------------------
+   HTTP transport: "gud serve" and http:// remotes
+-------------------------------------------*/
+
+const DEFAULT_SERVE_ADDR = ":8418"
+
+type uploadRequest struct {
+	Wants []string `json:"wants"`
+	Haves []string `json:"haves"`
+	Depth int      `json:"depth,omitempty"`
+}
+
+type uploadResponse struct {
+	Pack  []byte `json:"pack"`
+	Error string `json:"error,omitempty"`
+}
+
+type receiveRequest struct {
+	Pack    []byte      `json:"pack,omitempty"`
+	Updates []refUpdate `json:"updates"`
+}
+
+type receiveResponse struct {
+	Results []refUpdateResult `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+func serveCommand(args []string) {
+	addr := DEFAULT_SERVE_ADDR
+	readOnly := false
+	path := "."
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--addr" && i+1 < len(args):
+			addr = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--addr="):
+			addr = strings.TrimPrefix(args[i], "--addr=")
+		case args[i] == "--read-only":
+			readOnly = true
+		default:
+			path = args[i]
+		}
+	}
+	gudDir, err := localRemoteGudDir(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Serving %s on %s\n", path, addr)
+	if err := http.ListenAndServe(addr, newRepoHandler(gudDir, readOnly)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// newRepoHandler exposes one repository. Requests are matched on the path
+// suffix, so the repository can be mounted under any prefix.
+func newRepoHandler(gudDir string, readOnly bool) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info/refs") && r.Method == http.MethodGet:
+			writeJSON(w, advertiseRefsAt(gudDir))
+
+		case strings.HasSuffix(r.URL.Path, "/upload-pack") && r.Method == http.MethodPost:
+			var req uploadRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			pack, err := uploadPackAt(gudDir, req.Wants, req.Haves, req.Depth)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, uploadResponse{Pack: pack})
+
+		case strings.HasSuffix(r.URL.Path, "/receive-pack") && r.Method == http.MethodPost:
+			if readOnly {
+				http.Error(w, "repository is served read-only", http.StatusForbidden)
+				return
+			}
+			var req receiveRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			results, err := receiveObjectsAt(gudDir, req.Pack, req.Updates)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, receiveResponse{Results: results})
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// httpTransport is the client side of "gud serve".
+type httpTransport struct {
+	base   string
+	client *http.Client
+}
+
+func newHTTPTransport(url string) *httpTransport {
+	return &httpTransport{
+		base:   strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (t *httpTransport) call(method, endpoint string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequest(method, t.base+endpoint, body)
+	if err != nil {
+		return err
+	}
+	if req != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpResp.Body, 4096))
+		return fmt.Errorf("%s: %s", httpResp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+func (t *httpTransport) listRefs() (*refAdvertisement, error) {
+	var adv refAdvertisement
+	if err := t.call(http.MethodGet, "/info/refs", nil, &adv); err != nil {
+		return nil, err
+	}
+	if adv.Refs == nil {
+		adv.Refs = make(map[string]string)
+	}
+	return &adv, nil
+}
+
+func (t *httpTransport) fetchObjects(wants, haves []string, depth int) ([]byte, error) {
+	var resp uploadResponse
+	if err := t.call(http.MethodPost, "/upload-pack", uploadRequest{wants, haves, depth}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Pack, nil
+}
+
+func (t *httpTransport) pushObjects(pack []byte, updates []refUpdate) ([]refUpdateResult, error) {
+	var resp receiveResponse
+	if err := t.call(http.MethodPost, "/receive-pack", receiveRequest{pack, updates}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+func (t *httpTransport) close() {}
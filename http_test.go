@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// serveTestRepo starts "gud serve" on a bare repository whose main branch
+// holds the given commits, the last one at its tip.
+func serveTestRepo(t *testing.T, commits []packObject) (string, *httpTransport) {
+	t.Helper()
+	t.Setenv("GUD_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("GUD_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	gudDir := filepath.Join(t.TempDir(), "repo.gud")
+	writeLooseObjects(t, gudDir, commits)
+	saveBranchesAt(gudDir, map[string]string{"main": commits[len(commits)-1].ID})
+	saveTagsAt(gudDir, map[string]string{"v1": commits[0].ID})
+	if err := os.WriteFile(filepath.Join(gudDir, "HEAD"), []byte("main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newRepoHandler(gudDir, false))
+	t.Cleanup(server.Close)
+	return gudDir, newHTTPTransport(server.URL + "/repo")
+}
+
+// sideCommit makes a commit on top of parent that is not in commitChain.
+func sideCommit(parent string) packObject {
+	c := &Commit{Message: "side", Timestamp: "2024-01-02T00:00:00Z", Files: map[string]string{"side.txt": "x\n"}, Author: "B <b@example.com>", Parents: []string{parent}}
+	c.ID = commitHash(c)
+	data, _ := json.Marshal(c)
+	return packObject{c.ID, OBJ_COMMIT, data}
+}
+
+func packIDs(t *testing.T, pack []byte) []string {
+	t.Helper()
+	objects, _, err := decodePack(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, o := range objects {
+		ids = append(ids, o.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func objectIDs(objects []packObject) []string {
+	var ids []string
+	for _, o := range objects {
+		ids = append(ids, o.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestHTTPAdvertisesRefs(t *testing.T) {
+	commits := commitChain(3)
+	_, tr := serveTestRepo(t, commits)
+	adv, err := tr.listRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adv.Head != "main" {
+		t.Errorf("HEAD = %q, want main", adv.Head)
+	}
+	want := map[string]string{"refs/heads/main": commits[2].ID, "refs/tags/v1": commits[0].ID}
+	if len(adv.Refs) != len(want) {
+		t.Errorf("refs = %v, want %v", adv.Refs, want)
+	}
+	for ref, id := range want {
+		if adv.Refs[ref] != id {
+			t.Errorf("%s = %q, want %q", ref, adv.Refs[ref], id)
+		}
+	}
+}
+
+func TestHTTPUploadPack(t *testing.T) {
+	commits := commitChain(5)
+	_, tr := serveTestRepo(t, commits)
+	tip := commits[4].ID
+	tests := []struct {
+		name  string
+		haves []string
+		want  []packObject
+	}{
+		{"without haves", nil, commits},
+		{"with haves", []string{commits[2].ID}, commits[3:]},
+		{"unknown haves are ignored", []string{"0123456789012345678901234567890123456789"}, commits},
+		{"up to date", []string{tip}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack, err := tr.fetchObjects([]string{tip}, tt.haves, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, want := packIDs(t, pack), objectIDs(tt.want)
+			if len(got) != len(want) {
+				t.Fatalf("fetched %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("fetched %v, want %v", got, want)
+				}
+			}
+		})
+	}
+
+	if _, err := tr.fetchObjects([]string{"0123456789012345678901234567890123456789"}, nil, 0); err == nil {
+		t.Error("fetching an object the server does not have succeeded")
+	}
+}
+
+func TestHTTPReceivePack(t *testing.T) {
+	commits := commitChain(4)
+	gudDir, tr := serveTestRepo(t, commits)
+
+	next := commitChain(5)[4]
+	pack, _ := encodePack([]packObject{next})
+	results, err := tr.pushObjects(pack, []refUpdate{{Ref: "refs/heads/main", Old: commits[3].ID, New: next.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("fast-forward rejected: %+v", results)
+	}
+	if got := loadBranchesAt(gudDir)["main"]; got != next.ID {
+		t.Fatalf("main = %s after fast-forward, want %s", got, next.ID)
+	}
+
+	side := sideCommit(commits[1].ID)
+	pack, _ = encodePack([]packObject{side})
+	results, err = tr.pushObjects(pack, []refUpdate{{Ref: "refs/heads/main", Old: next.ID, New: side.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Error != "non-fast-forward" {
+		t.Fatalf("non-fast-forward push: %+v", results)
+	}
+	if got := loadBranchesAt(gudDir)["main"]; got != next.ID {
+		t.Fatalf("main moved to %s by a rejected push", got)
+	}
+}
+
+func TestHTTPUnknownPaths(t *testing.T) {
+	_, tr := serveTestRepo(t, commitChain(1))
+	tests := []struct {
+		method, path string
+	}{
+		{http.MethodGet, ""},
+		{http.MethodGet, "/objects/pack"},
+		{http.MethodGet, "/info/refs/extra"},
+		{http.MethodGet, "/upload-pack"},
+		{http.MethodPost, "/info/refs"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tr.base+tt.path, nil)
+		resp, err := tr.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s: status %d, want 404", tt.method, tt.path, resp.StatusCode)
+		}
+	}
+}
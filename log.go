@@ -0,0 +1,921 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   gud log: walk the commit graph
+-------------------------------------------*/
+
+const LOG_DATE_FORMAT = "Mon Jan 2 15:04:05 2006 -0700"
+
+type logOptions struct {
+	starts        []string
+	excludes      []string
+	symmetric     [][2]string // A...B pairs
+	max           int         // -1 means no limit
+	since, until  time.Time
+	authors       []*regexp.Regexp
+	greps         []*regexp.Regexp
+	paths         []string
+	format        string // "oneline", "short", "medium", "full" or "format:<template>"
+	graph         bool
+	decorate      bool
+	firstParent   bool
+	showSignature bool
+	patch         bool
+	follow        bool
+	followed      map[string]string // with --follow, the file's name in each commit
+	diff          diffOptions
+}
+
+func logUsage() {
+	fmt.Println("Usage: gud log [--oneline] [--format=<template>] [--graph] [-n <count>] [--since=<date>] [--until=<date>]")
+	fmt.Println("               [--author=<pattern>] [--grep=<pattern>] [--all] [--first-parent] [--show-signature]")
+	fmt.Println("               [-p] [--follow] [-M[<n>]] [-C[<n>]] [--no-renames] [-w] [--word-diff[=<mode>]]")
+	fmt.Println("               [<revision> | <A>..<B> | <A>...<B> | ^<revision>]... [-- <path>...]")
+}
+
+// parseLogArgs reads log options, revisions and paths. An argument that is
+// not a revision but names a file is taken as a path, as if after "--".
+func parseLogArgs(args []string) (*logOptions, error) {
+	opts := &logOptions{format: "medium", decorate: true, max: -1}
+	all := false
+	args, diff, err := diffFlags(args, defaultDiffOptions())
+	if err != nil {
+		return nil, err
+	}
+	opts.diff = diff
+	// value returns the argument of "--opt=value" or "--opt value".
+	value := func(i *int, a, name string) (string, bool) {
+		if strings.HasPrefix(a, name+"=") {
+			return strings.TrimPrefix(a, name+"="), true
+		}
+		if a == name && *i+1 < len(args) {
+			*i++
+			return args[*i], true
+		}
+		return "", false
+	}
+	date := func(s string) (time.Time, error) {
+		t, err := parseExpiry(s)
+		if err != nil {
+			return t, fmt.Errorf("invalid date: %s", s)
+		}
+		return t, nil
+	}
+
+	var revs []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if v, ok := value(&i, a, "--max-count"); ok {
+			a = "-n" + v
+		} else if a == "-n" && i+1 < len(args) {
+			i++
+			a = "-n" + args[i]
+		}
+		switch {
+		case a == "--":
+			opts.paths = append(opts.paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(a, "-n") && len(a) > 2:
+			n, err := strconv.Atoi(a[2:])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid count: %s", a[2:])
+			}
+			opts.max = n
+		case len(a) > 1 && a[0] == '-' && a[1] >= '0' && a[1] <= '9':
+			n, err := strconv.Atoi(a[1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid count: %s", a[1:])
+			}
+			opts.max = n
+		case a == "--oneline":
+			opts.format = "oneline"
+		case strings.HasPrefix(a, "--format=") || strings.HasPrefix(a, "--pretty="):
+			opts.format = prettyFormat(a[strings.Index(a, "=")+1:])
+		case a == "--graph":
+			opts.graph = true
+		case a == "--all":
+			all = true
+		case a == "--first-parent":
+			opts.firstParent = true
+		case a == "-p" || a == "--patch":
+			opts.patch = true
+		case a == "--follow":
+			opts.follow = true
+		case a == "--show-signature":
+			opts.showSignature = true
+		case a == "--decorate":
+			opts.decorate = true
+		case a == "--no-decorate":
+			opts.decorate = false
+		case strings.HasPrefix(a, "--since") || strings.HasPrefix(a, "--after"):
+			v, ok := value(&i, a, a[:strings.IndexAny(a+"=", "=")])
+			if !ok {
+				return nil, fmt.Errorf("%s needs a date", a)
+			}
+			t, err := date(v)
+			if err != nil {
+				return nil, err
+			}
+			opts.since = t
+		case strings.HasPrefix(a, "--until") || strings.HasPrefix(a, "--before"):
+			v, ok := value(&i, a, a[:strings.IndexAny(a+"=", "=")])
+			if !ok {
+				return nil, fmt.Errorf("%s needs a date", a)
+			}
+			t, err := date(v)
+			if err != nil {
+				return nil, err
+			}
+			opts.until = t
+		case strings.HasPrefix(a, "--author") || strings.HasPrefix(a, "--grep"):
+			name := a[:strings.IndexAny(a+"=", "=")]
+			v, ok := value(&i, a, name)
+			if !ok {
+				return nil, fmt.Errorf("%s needs a pattern", a)
+			}
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", v, err)
+			}
+			if name == "--author" {
+				opts.authors = append(opts.authors, re)
+			} else {
+				opts.greps = append(opts.greps, re)
+			}
+		case strings.HasPrefix(a, "-") && a != "-":
+			return nil, fmt.Errorf("unknown option: %s", a)
+		default:
+			revs = append(revs, a)
+		}
+	}
+
+	for _, rev := range revs {
+		if err := addLogRevision(opts, rev); err != nil {
+			if _, statErr := os.Stat(rev); statErr == nil || containsKey(getLastCommitFiles(), rev) {
+				opts.paths = append(opts.paths, rev)
+				continue
+			}
+			return nil, err
+		}
+	}
+	if all {
+		for _, name := range sortedKeys(loadBranches()) {
+			opts.starts = append(opts.starts, loadBranches()[name])
+		}
+		heads, _ := peelAt(GUD_DIR, tagValues())
+		opts.starts = append(opts.starts, heads...)
+		opts.starts = append(opts.starts, allRemoteTrackingHeads()...)
+	}
+	if opts.follow && len(opts.paths) != 1 {
+		return nil, fmt.Errorf("--follow requires exactly one path")
+	}
+	if len(opts.starts) == 0 && len(opts.symmetric) == 0 && !all {
+		head := currentBranchHead()
+		if head == "" {
+			return nil, fmt.Errorf("your current branch '%s' does not have any commits yet", currentBranch())
+		}
+		opts.starts = append(opts.starts, head)
+	}
+	return opts, nil
+}
+
+func containsKey(m map[string]string, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+func tagValues() []string {
+	var ids []string
+	for _, id := range loadTags() {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// prettyFormat reads the value of --format or --pretty: a named format or
+// a template, optionally prefixed with "format:" or "tformat:".
+func prettyFormat(f string) string {
+	switch {
+	case f == "oneline" || f == "short" || f == "medium" || f == "full":
+		return f
+	case strings.HasPrefix(f, "format:") || strings.HasPrefix(f, "tformat:"):
+		return "format:" + f[strings.Index(f, ":")+1:]
+	}
+	return "format:" + f
+}
+
+// addLogRevision adds "A", "^A", "A..B" or "A...B" to the walk.
+func addLogRevision(opts *logOptions, rev string) error {
+	resolve := func(s string) (string, error) {
+		if s == "" {
+			s = "HEAD"
+		}
+		return resolveRevision(s)
+	}
+	if i := strings.Index(rev, "..."); i >= 0 {
+		a, err := resolve(rev[:i])
+		if err != nil {
+			return err
+		}
+		b, err := resolve(rev[i+3:])
+		if err != nil {
+			return err
+		}
+		opts.symmetric = append(opts.symmetric, [2]string{a, b})
+		return nil
+	}
+	if i := strings.Index(rev, ".."); i >= 0 {
+		a, err := resolve(rev[:i])
+		if err != nil {
+			return err
+		}
+		b, err := resolve(rev[i+2:])
+		if err != nil {
+			return err
+		}
+		opts.excludes = append(opts.excludes, a)
+		opts.starts = append(opts.starts, b)
+		return nil
+	}
+	if strings.HasPrefix(rev, "^") {
+		id, err := resolve(rev[1:])
+		if err != nil {
+			return err
+		}
+		opts.excludes = append(opts.excludes, id)
+		return nil
+	}
+	id, err := resolve(rev)
+	if err != nil {
+		return err
+	}
+	opts.starts = append(opts.starts, id)
+	return nil
+}
+
+func logCommand(args []string) {
+	opts, err := parseLogArgs(args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		logUsage()
+		return
+	}
+	commits, parents := selectCommits(opts)
+
+	var decor map[string][]string
+	if opts.decorate {
+		decor = decorations()
+	}
+	var signers map[string]string
+	if opts.showSignature {
+		if signers, err = loadAllowedSigners(); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	var graph *logGraph
+	if opts.graph {
+		graph = &logGraph{}
+	}
+	for n, c := range commits {
+		lines := formatCommit(opts, c, decor, signers)
+		if opts.patch {
+			lines = append(lines, commitPatchLines(opts, c)...)
+		}
+		if n < len(commits)-1 && opts.format != "oneline" && !strings.HasPrefix(opts.format, "format:") {
+			lines = append(lines, "")
+		}
+		if graph == nil {
+			for _, l := range lines {
+				fmt.Println(l)
+			}
+			continue
+		}
+		fmt.Println(strings.TrimRight(graph.commitRow(c.ID)+lines[0], " "))
+		// The lines that route the columns to the parents run alongside
+		// the rest of the commit's text.
+		width := len(graph.padding())
+		routes, rest := graph.next(parents[c.ID]), lines[1:]
+		width = max(width, len(graph.padding()))
+		for _, r := range routes {
+			width = max(width, len(r))
+		}
+		for i := 0; i < len(routes) || i < len(rest); i++ {
+			prefix, text := graph.padding(), ""
+			if i < len(routes) {
+				prefix = routes[i]
+			}
+			if i < len(rest) {
+				text = rest[i]
+			}
+			fmt.Println(strings.TrimRight(fmt.Sprintf("%-*s", width, prefix)+text, " "))
+		}
+	}
+}
+
+/* ----------------------------------------
+   Choosing and ordering commits
+-------------------------------------------*/
+
+// selectCommits walks from the start revisions, leaving out everything the
+// excluded revisions reach, and keeps the commits that pass the filters,
+// newest first but never before a child. The returned parents skip over
+// commits that were filtered out, so the graph still connects.
+func selectCommits(opts *logOptions) ([]*Commit, map[string][]string) {
+	excluded := reachableForLog(opts, opts.excludes)
+	starts := append([]string(nil), opts.starts...)
+	for _, pair := range opts.symmetric {
+		left := reachableForLog(opts, pair[:1])
+		right := reachableForLog(opts, pair[1:])
+		for id := range left {
+			if right[id] {
+				excluded[id] = true
+			}
+		}
+		starts = append(starts, pair[0], pair[1])
+	}
+
+	all := make(map[string]*Commit)
+	stack := starts
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == "" || excluded[id] || all[id] != nil {
+			continue
+		}
+		c, err := readCommit(id)
+		if err != nil {
+			continue
+		}
+		all[id] = c
+		stack = append(stack, walkParents(opts, c)...)
+	}
+
+	if opts.follow {
+		opts.followed = followRenames(opts, all, opts.paths[0])
+	}
+	visible := make(map[string]bool)
+	for id, c := range all {
+		if commitMatches(opts, c) {
+			visible[id] = true
+		}
+	}
+
+	// Parents rewritten to the nearest visible ancestors.
+	memo := make(map[string][]string)
+	var nearest func(id string) []string
+	nearest = func(id string) []string {
+		if r, ok := memo[id]; ok {
+			return r
+		}
+		memo[id] = nil
+		var result []string
+		if c := all[id]; c != nil {
+			for _, p := range walkParents(opts, c) {
+				if visible[p] {
+					result = appendUnique(result, p)
+					continue
+				}
+				for _, q := range nearest(p) {
+					result = appendUnique(result, q)
+				}
+			}
+		}
+		memo[id] = result
+		return result
+	}
+	parents := make(map[string][]string)
+	children := make(map[string]int)
+	for id := range visible {
+		parents[id] = nearest(id)
+		for _, p := range parents[id] {
+			children[p]++
+		}
+	}
+
+	queue := &commitQueue{}
+	for id := range visible {
+		if children[id] == 0 {
+			heap.Push(queue, all[id])
+		}
+	}
+	var ordered []*Commit
+	for queue.Len() > 0 && (opts.max < 0 || len(ordered) < opts.max) {
+		c := heap.Pop(queue).(*Commit)
+		ordered = append(ordered, c)
+		for _, p := range parents[c.ID] {
+			children[p]--
+			if children[p] == 0 {
+				heap.Push(queue, all[p])
+			}
+		}
+	}
+	return ordered, parents
+}
+
+func walkParents(opts *logOptions, c *Commit) []string {
+	if opts.firstParent && len(c.Parents) > 1 {
+		return c.Parents[:1]
+	}
+	return c.Parents
+}
+
+func reachableForLog(opts *logOptions, heads []string) map[string]bool {
+	if !opts.firstParent {
+		return reachableCommitsAt(GUD_DIR, heads, nil)
+	}
+	seen := make(map[string]bool)
+	for _, id := range heads {
+		for id != "" && !seen[id] {
+			seen[id] = true
+			c, err := readCommit(id)
+			if err != nil || len(c.Parents) == 0 {
+				break
+			}
+			id = c.Parents[0]
+		}
+	}
+	return seen
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// commitQueue orders commits newest first, by timestamp and then ID.
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	ti, tj := commitTime(q[i]), commitTime(q[j])
+	if !ti.Equal(tj) {
+		return ti.After(tj)
+	}
+	return q[i].ID > q[j].ID
+}
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*Commit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+func commitTime(c *Commit) time.Time {
+	t, _ := time.Parse(time.RFC3339, c.Timestamp)
+	return t
+}
+
+// commitMatches applies the date, author, message and path filters.
+func commitMatches(opts *logOptions, c *Commit) bool {
+	t := commitTime(c)
+	if !opts.since.IsZero() && t.Before(opts.since) {
+		return false
+	}
+	if !opts.until.IsZero() && t.After(opts.until) {
+		return false
+	}
+	if len(opts.authors) > 0 && !anyMatch(opts.authors, c.Author) {
+		return false
+	}
+	if len(opts.greps) > 0 && !anyMatch(opts.greps, c.Message) {
+		return false
+	}
+	if len(opts.paths) > 0 && !touchesPaths(c, commitPaths(opts, c.ID)) {
+		return false
+	}
+	return true
+}
+
+// commitPaths returns the paths that limit the log at a commit: the given
+// paths, or with --follow the name the followed file had there.
+func commitPaths(opts *logOptions, id string) []string {
+	if opts.followed != nil {
+		return []string{opts.followed[id]}
+	}
+	return opts.paths
+}
+
+// followRenames works out the followed file's name in every commit, going
+// from children to parents so that a rename found in a commit carries over
+// to all of its ancestors.
+func followRenames(opts *logOptions, all map[string]*Commit, path string) map[string]string {
+	children := make(map[string]int)
+	for _, c := range all {
+		for _, p := range walkParents(opts, c) {
+			children[p]++
+		}
+	}
+	names := make(map[string]string)
+	queue := &commitQueue{}
+	for id, c := range all {
+		if children[id] == 0 {
+			names[id] = path
+			heap.Push(queue, c)
+		}
+	}
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(*Commit)
+		name := names[c.ID]
+		for _, p := range walkParents(opts, c) {
+			if all[p] == nil {
+				continue
+			}
+			if _, done := names[p]; !done {
+				names[p] = name
+				if content, ok := c.Files[name]; ok {
+					if old, ok := renameSource(commitFiles(p), c.Files, name, content); ok {
+						names[p] = old
+					}
+				}
+			}
+			children[p]--
+			if children[p] == 0 {
+				heap.Push(queue, all[p])
+			}
+		}
+	}
+	return names
+}
+
+// commitPatchLines renders a commit's changes against its parent for -p,
+// limited to the log's paths. Merges have no patch.
+func commitPatchLines(opts *logOptions, c *Commit) []string {
+	if len(c.Parents) > 1 {
+		return nil
+	}
+	var parent map[string]string
+	paths := commitPaths(opts, c.ID)
+	if len(c.Parents) == 1 {
+		parent = commitFiles(c.Parents[0])
+		if opts.followed != nil {
+			paths = appendUnique(paths, opts.followed[c.Parents[0]])
+		}
+	}
+	patch := treePatch(limitFiles(parent, paths), limitFiles(c.Files, paths), opts.diff)
+	if patch == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	if opts.format == "oneline" || strings.HasPrefix(opts.format, "format:") {
+		return lines
+	}
+	return append([]string{""}, lines...)
+}
+
+// limitFiles keeps the files under paths; no paths keeps everything.
+func limitFiles(files map[string]string, paths []string) map[string]string {
+	if len(paths) == 0 {
+		return files
+	}
+	limited := make(map[string]string)
+	for p, content := range files {
+		if matchesPaths(p, paths) {
+			limited[p] = content
+		}
+	}
+	return limited
+}
+
+func anyMatch(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// touchesPaths reports whether a commit changed any of the paths. A merge
+// counts only if its result differs from every parent.
+func touchesPaths(c *Commit, paths []string) bool {
+	if len(c.Parents) == 0 {
+		return changedPaths(nil, c.Files, paths)
+	}
+	for _, p := range c.Parents {
+		if !changedPaths(commitFiles(p), c.Files, paths) {
+			return false
+		}
+	}
+	return true
+}
+
+// changedPaths reports whether any file under paths differs between a and b.
+func changedPaths(a, b map[string]string, paths []string) bool {
+	for p, v := range b {
+		if w, ok := a[p]; (!ok || w != v) && matchesPaths(p, paths) {
+			return true
+		}
+	}
+	for p := range a {
+		if _, ok := b[p]; !ok && matchesPaths(p, paths) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPaths reports whether file is one of paths or inside one of them.
+func matchesPaths(file string, paths []string) bool {
+	for _, p := range paths {
+		p = strings.TrimSuffix(strings.TrimPrefix(p, "./"), "/")
+		if p == "." || p == "" || file == p || strings.HasPrefix(file, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+/* ----------------------------------------
+   Formatting
+-------------------------------------------*/
+
+// decorations maps commits to the refs that point at them.
+func decorations() map[string][]string {
+	decor := make(map[string][]string)
+	head, current := currentBranchHead(), currentBranch()
+	if head != "" {
+		decor[head] = append(decor[head], "HEAD -> "+current)
+	}
+	branches := loadBranches()
+	for _, name := range sortedKeys(branches) {
+		if name != current {
+			decor[branches[name]] = append(decor[branches[name]], name)
+		}
+	}
+	for _, remote := range remoteNames() {
+		refs := loadRemoteRefs(remote)
+		for _, name := range sortedKeys(refs) {
+			decor[refs[name]] = append(decor[refs[name]], remote+"/"+name)
+		}
+	}
+	tags := loadTags()
+	for _, name := range sortedKeys(tags) {
+		id := peelTag(tags[name])
+		decor[id] = append(decor[id], "tag: "+name)
+	}
+	return decor
+}
+
+func formatDecoration(decor map[string][]string, id string) string {
+	if len(decor[id]) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(decor[id], ", ") + ")"
+}
+
+// splitIdentity splits "Name <email>" into its parts.
+func splitIdentity(s string) (string, string) {
+	i := strings.Index(s, "<")
+	if i < 0 {
+		return strings.TrimSpace(s), ""
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSuffix(strings.TrimSpace(s[i+1:]), ">")
+}
+
+func formatDate(timestamp string) string {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Format(LOG_DATE_FORMAT)
+}
+
+func committerOf(c *Commit) string {
+	if c.Committer != "" {
+		return c.Committer
+	}
+	return c.Author
+}
+
+// formatCommit renders one commit as lines of text.
+func formatCommit(opts *logOptions, c *Commit, decor map[string][]string, signers map[string]string) []string {
+	var sig []string
+	if opts.showSignature && c.Signature != nil {
+		sig = append(sig, verifyCommit(c, signers).String())
+	}
+	if strings.HasPrefix(opts.format, "format:") {
+		text := expandFormat(strings.TrimPrefix(opts.format, "format:"), c, decor)
+		return append(sig, strings.Split(text, "\n")...)
+	}
+	if opts.format == "oneline" {
+		line := shortID(c.ID) + formatDecoration(decor, c.ID) + " " + subjectLine(c.Message)
+		return append([]string{line}, sig...)
+	}
+
+	lines := []string{"commit " + c.ID + formatDecoration(decor, c.ID)}
+	if len(c.Parents) > 1 {
+		var short []string
+		for _, p := range c.Parents {
+			short = append(short, shortID(p))
+		}
+		lines = append(lines, "Merge: "+strings.Join(short, " "))
+	}
+	lines = append(lines, sig...)
+	lines = append(lines, "Author: "+c.Author)
+	if opts.format == "full" {
+		lines = append(lines, "Commit: "+committerOf(c))
+	}
+	if opts.format == "medium" {
+		lines = append(lines, "Date:   "+formatDate(c.Timestamp))
+	}
+	lines = append(lines, "")
+	message := c.Message
+	if opts.format == "short" {
+		message = subjectLine(message)
+	}
+	for _, l := range strings.Split(message, "\n") {
+		lines = append(lines, strings.TrimRight("    "+l, " "))
+	}
+	return lines
+}
+
+// expandFormat fills in a --format template:
+//
+//	%H %h  commit ID, abbreviated     %P %p  parent IDs, abbreviated
+//	%an %ae %ad  author name, email, date
+//	%cn %ce %cd  committer name, email, date
+//	%s subject  %b body  %B raw message
+//	%d " (refs)"  %D "refs"  %n newline  %% a literal %
+func expandFormat(tmpl string, c *Commit, decor map[string][]string) string {
+	var b strings.Builder
+	an, ae := splitIdentity(c.Author)
+	cn, ce := splitIdentity(committerOf(c))
+	body := ""
+	if parts := strings.SplitN(c.Message, "\n", 2); len(parts) == 2 {
+		body = strings.TrimLeft(parts[1], "\n")
+	}
+	var short []string
+	for _, p := range c.Parents {
+		short = append(short, shortID(p))
+	}
+	placeholders := map[string]string{
+		"H":  c.ID,
+		"h":  shortID(c.ID),
+		"P":  strings.Join(c.Parents, " "),
+		"p":  strings.Join(short, " "),
+		"an": an,
+		"ae": ae,
+		"ad": formatDate(c.Timestamp),
+		"cn": cn,
+		"ce": ce,
+		"cd": formatDate(c.Timestamp),
+		"s":  subjectLine(c.Message),
+		"b":  body,
+		"B":  c.Message,
+		"d":  formatDecoration(decor, c.ID),
+		"D":  strings.Join(decor[c.ID], ", "),
+		"n":  "\n",
+		"%":  "%",
+	}
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '%' || i+1 >= len(tmpl) {
+			b.WriteByte(tmpl[i])
+			continue
+		}
+		if v, ok := placeholders[tmpl[i+1:min(i+3, len(tmpl))]]; ok && i+3 <= len(tmpl) {
+			b.WriteString(v)
+			i += 2
+			continue
+		}
+		if v, ok := placeholders[tmpl[i+1:i+2]]; ok {
+			b.WriteString(v)
+			i++
+			continue
+		}
+		b.WriteByte('%')
+	}
+	return b.String()
+}
+
+/* ----------------------------------------
+   ASCII graph
+-------------------------------------------*/
+
+// logGraph draws the lines of history to the left of each commit. cols
+// holds the commit each column is waiting for.
+type logGraph struct {
+	cols []string
+	row  int // column of the commit drawn last
+}
+
+// commitRow places the commit in its column (or a new one) and returns the
+// row's prefix.
+func (g *logGraph) commitRow(id string) string {
+	idx := g.column(id)
+	if idx < 0 {
+		g.cols = append(g.cols, id)
+		idx = len(g.cols) - 1
+	}
+	g.row = idx
+	var b strings.Builder
+	for i := range g.cols {
+		if i == idx {
+			b.WriteString("* ")
+		} else {
+			b.WriteString("| ")
+		}
+	}
+	return b.String()
+}
+
+func (g *logGraph) column(id string) int {
+	for i, c := range g.cols {
+		if c == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// next replaces the commit just drawn with its parents and returns the
+// lines that route each column to its new position. A parent that another
+// column already waits for joins that column.
+func (g *logGraph) next(parents []string) []string {
+	type edge struct{ from, to int }
+	idx := g.row
+
+	var next []string
+	var edges []edge
+	place := func(from int, id string) {
+		for j, c := range next {
+			if c == id {
+				edges = append(edges, edge{from, j})
+				return
+			}
+		}
+		next = append(next, id)
+		edges = append(edges, edge{from, len(next) - 1})
+	}
+	for i, c := range g.cols {
+		if i != idx {
+			place(i, c)
+			continue
+		}
+		for _, p := range parents {
+			place(i, p)
+		}
+	}
+	g.cols = next
+
+	straight := true
+	for _, e := range edges {
+		if e.from != e.to {
+			straight = false
+		}
+	}
+	if straight {
+		return nil
+	}
+
+	pos := make([]int, len(edges))
+	for i, e := range edges {
+		pos[i] = e.from
+	}
+	var lines []string
+	for {
+		moving := false
+		row := []byte(strings.Repeat(" ", 2*(len(edges)+len(next))+2))
+		for i, e := range edges {
+			switch {
+			case pos[i] < e.to:
+				row[2*pos[i]+1] = '\\'
+				pos[i]++
+				moving = true
+			case pos[i] > e.to:
+				row[2*pos[i]-1] = '/'
+				pos[i]--
+				moving = true
+			default:
+				if row[2*pos[i]] == ' ' {
+					row[2*pos[i]] = '|'
+				}
+			}
+		}
+		if !moving {
+			break
+		}
+		lines = append(lines, strings.TrimRight(string(row), " "))
+	}
+	return lines
+}
+
+// padding continues every column for lines that belong to the last commit.
+func (g *logGraph) padding() string {
+	return strings.Repeat("| ", len(g.cols))
+}
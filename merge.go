@@ -0,0 +1,747 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ----------------------------------------
+   Line diffs and three-way merging
+-------------------------------------------*/
+
+const (
+	MERGE_HEAD_FILE      = ".gud/MERGE_HEAD"
+	MERGE_MSG_FILE       = ".gud/MERGE_MSG"
+	MERGE_CONFLICTS_FILE = ".gud/MERGE_CONFLICTS"
+)
+
+// MYERS_MAX_COST bounds the rounds of one middle snake search. Diffs with
+// up to twice as many changed lines are minimal; larger ones are found in
+// O((N+M)*MYERS_MAX_COST) time instead of O((N+M)*D).
+const MYERS_MAX_COST = 1024
+
+// diffOp is one step of an edit script: ' ' keeps a[A] (== b[B]), '-'
+// deletes a[A] and '+' inserts b[B].
+type diffOp struct {
+	Kind byte
+	A, B int
+}
+
+// splitLines splits text into lines that keep their "\n" terminator, so that
+// joining them reproduces the input exactly.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes an edit script from a to b (Myers' algorithm), the
+// shortest one unless the files differ in thousands of lines.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', i, i})
+	}
+	for _, op := range myersDiff(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		op.A += pre
+		op.B += pre
+		ops = append(ops, op)
+	}
+	for i := 0; i < suf; i++ {
+		ops = append(ops, diffOp{' ', len(a) - suf + i, len(b) - suf + i})
+	}
+	return ops
+}
+
+// myersDiff computes the edit script with the linear-space variant of
+// Myers' algorithm: it finds the middle snake of an optimal path and
+// recurses on both sides of it, so memory stays O(len(a)+len(b)) however
+// different the inputs are.
+func myersDiff(a, b []string) []diffOp {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	// Compare small integers instead of lines.
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &myers{a: intern(a)}
+	fromA := len(ids)
+	d.b = intern(b)
+	if len(ids) == fromA+len(b) {
+		// No line in common: replace everything without searching.
+		for i := range a {
+			d.ops = append(d.ops, diffOp{'-', i, 0})
+		}
+		for j := range b {
+			d.ops = append(d.ops, diffOp{'+', len(a), j})
+		}
+		return d.ops
+	}
+	size := 2*((len(a)+len(b)+1)/2) + 3
+	d.vf, d.vb = make([]int, size), make([]int, size)
+	d.ops = make([]diffOp, 0, len(a)+len(b))
+	d.compare(0, len(a), 0, len(b))
+	return deletionsFirst(d.ops)
+}
+
+type myers struct {
+	a, b   []int
+	vf, vb []int // furthest reaching x per diagonal, forward and backward
+	ops    []diffOp
+}
+
+// compare appends the edit script of a[aLo:aHi] against b[bLo:bHi].
+func (d *myers) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, diffOp{' ', aLo, bLo})
+		aLo++
+		bLo++
+	}
+	suf := 0
+	for aLo < aHi-suf && bLo < bHi-suf && d.a[aHi-1-suf] == d.b[bHi-1-suf] {
+		suf++
+	}
+	aHi, bHi = aHi-suf, bHi-suf
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.ops = append(d.ops, diffOp{'+', aLo, j})
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.ops = append(d.ops, diffOp{'-', i, bLo})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, diffOp{' ', x, y})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for i := 0; i < suf; i++ {
+		d.ops = append(d.ops, diffOp{' ', aHi + i, bHi + i})
+	}
+}
+
+// middleSnake runs the search forwards from the start and backwards from
+// the end until the two meet, and returns the snake (x, y)-(u, v) where they
+// do. Both ends differ, so the snake splits the problem into smaller ones.
+// After MYERS_MAX_COST rounds it gives up on a minimal script and splits at
+// the point the forward search got furthest.
+func (d *myers) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	off := len(d.vf) / 2
+	d.vf[off+1], d.vb[off+1] = 0, 0
+	D := 0
+	for ; D <= (n+m+1)/2 && D <= MYERS_MAX_COST; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && d.vf[off+k-1] < d.vf[off+k+1]) {
+				x = d.vf[off+k+1]
+			} else {
+				x = d.vf[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			d.vf[off+k] = x
+			if odd && k >= delta-(D-1) && k <= delta+(D-1) && x+d.vb[off+delta-k] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && d.vb[off+k-1] < d.vb[off+k+1]) {
+				x = d.vb[off+k+1]
+			} else {
+				x = d.vb[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			d.vb[off+k] = x
+			if kf := delta - k; !odd && kf >= -D && kf <= D && d.vf[off+kf]+x >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	bestX, bestY := 0, 0
+	for k := -(D - 1); k <= D-1; k += 2 {
+		x := d.vf[off+k]
+		if y := x - k; x <= n && y >= 0 && y <= m && x+y > bestX+bestY {
+			bestX, bestY = x, y
+		}
+	}
+	return aLo + bestX, bLo + bestY, aLo + bestX, bLo + bestY
+}
+
+// deletionsFirst reorders each run of changes so that its deletions come
+// before its insertions, as patches show them.
+func deletionsFirst(ops []diffOp) []diffOp {
+	out := make([]diffOp, 0, len(ops))
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			out = append(out, ops[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(ops) && ops[j].Kind != ' ' {
+			j++
+		}
+		// The run replaces a[aStart:aEnd] with b[bStart:bEnd].
+		aStart, bStart := ops[i].A, ops[i].B
+		aEnd := aStart
+		for _, op := range ops[i:j] {
+			if op.Kind == '-' {
+				out = append(out, diffOp{'-', op.A, bStart})
+				aEnd = op.A + 1
+			}
+		}
+		for _, op := range ops[i:j] {
+			if op.Kind == '+' {
+				out = append(out, diffOp{'+', aEnd, op.B})
+			}
+		}
+		i = j
+	}
+	return out
+}
+
+// lineMatches maps each line of base to the matching line of other, or -1.
+func lineMatches(base, other []string) []int {
+	m := make([]int, len(base))
+	for i := range m {
+		m[i] = -1
+	}
+	for _, op := range diffLines(base, other) {
+		if op.Kind == ' ' {
+			m[op.A] = op.B
+		}
+	}
+	return m
+}
+
+func sameLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge3 merges the changes from base to ours and from base to theirs. Lines
+// that both sides changed differently are wrapped in conflict markers using
+// the configured merge.conflictstyle ("merge" or "diff3").
+func merge3(base, ours, theirs, oursLabel, theirsLabel string) (string, bool) {
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	mo, mt := lineMatches(b, o), lineMatches(b, t)
+	diff3Style := configString("merge.conflictstyle", "merge") == "diff3"
+
+	var out strings.Builder
+	conflict := false
+	emit := func(lines []string) {
+		for _, l := range lines {
+			out.WriteString(l)
+		}
+	}
+	marker := func(m string, lines []string) {
+		if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString(m + "\n")
+		emit(lines)
+	}
+	resolve := func(bc, oc, tc []string) {
+		switch {
+		case sameLines(oc, bc):
+			emit(tc)
+		case sameLines(tc, bc), sameLines(oc, tc):
+			emit(oc)
+		default:
+			conflict = true
+			marker("<<<<<<< "+oursLabel, oc)
+			if diff3Style {
+				marker("||||||| base", bc)
+			}
+			marker("=======", tc)
+			marker(">>>>>>> "+theirsLabel, nil)
+		}
+	}
+
+	i, j, k := 0, 0, 0
+	for {
+		s := i
+		for s < len(b) && (mo[s] < 0 || mt[s] < 0) {
+			s++
+		}
+		if s == len(b) {
+			resolve(b[i:], o[j:], t[k:])
+			break
+		}
+		if s > i || mo[s] > j || mt[s] > k {
+			resolve(b[i:s], o[j:mo[s]], t[k:mt[s]])
+		}
+		out.WriteString(b[s])
+		i, j, k = s+1, mo[s]+1, mt[s]+1
+	}
+	return out.String(), conflict
+}
+
+// mergeTrees merges two snapshots against their common base. It returns the
+// merged snapshot (conflicted files contain markers) and the conflicted paths.
+func mergeTrees(base, ours, theirs map[string]string, oursLabel, theirsLabel string) (map[string]string, []string) {
+	base, ours, theirs = alignRenames(base, ours, theirs)
+	paths := make(map[string]bool)
+	for _, m := range []map[string]string{base, ours, theirs} {
+		for p := range m {
+			paths[p] = true
+		}
+	}
+
+	result := make(map[string]string)
+	var conflicts []string
+	for p := range paths {
+		b, inB := base[p]
+		o, inO := ours[p]
+		t, inT := theirs[p]
+		switch {
+		case inO == inT && o == t:
+			if inO {
+				result[p] = o
+			}
+		case inO == inB && o == b:
+			if inT {
+				result[p] = t
+			}
+		case inT == inB && t == b:
+			if inO {
+				result[p] = o
+			}
+		case inO && inT:
+			merged, conflict := merge3(b, o, t, oursLabel, theirsLabel)
+			result[p] = merged
+			if conflict {
+				conflicts = append(conflicts, p)
+			}
+		default:
+			// Modified on one side, deleted on the other: keep the
+			// modified content so nothing is lost, but flag it.
+			if inO {
+				result[p] = o
+			} else {
+				result[p] = t
+			}
+			conflicts = append(conflicts, p)
+		}
+	}
+	sort.Strings(conflicts)
+	return result, conflicts
+}
+
+/* ----------------------------------------
+   Working tree updates
+-------------------------------------------*/
+
+func readWorkingFile(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func writeWorkingFile(path, content string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// blockedPaths lists files that moving the working tree from one snapshot to
+// another would clobber: tracked files with local modifications and
+// untracked files in the way.
+func blockedPaths(from, to map[string]string) []string {
+	var blocked []string
+	check := func(p string) {
+		want, inTo := to[p]
+		have, inFrom := from[p]
+		if inTo == inFrom && want == have {
+			return
+		}
+		disk, onDisk := readWorkingFile(p)
+		if onDisk == inFrom && disk == have {
+			return
+		}
+		if onDisk && inTo && disk == want {
+			return
+		}
+		blocked = append(blocked, p)
+	}
+	for p := range to {
+		check(p)
+	}
+	for p := range from {
+		if _, ok := to[p]; !ok {
+			check(p)
+		}
+	}
+	sort.Strings(blocked)
+	return blocked
+}
+
+// updateWorkingTree rewrites the working copy from one snapshot to another,
+// removing files that are no longer tracked.
+func updateWorkingTree(from, to map[string]string) error {
+	for p, content := range to {
+		if old, ok := from[p]; ok && old == content {
+			if disk, onDisk := readWorkingFile(p); onDisk && disk == content {
+				continue
+			}
+		}
+		if err := writeWorkingFile(p, content); err != nil {
+			return err
+		}
+	}
+	for p := range from {
+		if _, ok := to[p]; !ok {
+			os.Remove(p)
+		}
+	}
+	return nil
+}
+
+// checkoutBranch switches HEAD to branch and updates the working tree,
+// refusing if local changes would be lost.
+func checkoutBranch(branch string) bool {
+	branches := loadBranches()
+	target, ok := branches[branch]
+	if !ok {
+		fmt.Println("Branch not found:", branch)
+		return false
+	}
+	if branch == currentBranch() {
+		return true
+	}
+	if len(loadStaging()) > 0 || len(loadStagedRemovals()) > 0 {
+		fmt.Println("You have staged changes; commit or unstage them before switching branches.")
+		return false
+	}
+	from := commitFiles(currentBranchHead())
+	to := commitFiles(target)
+	if blocked := blockedPaths(from, to); len(blocked) > 0 {
+		fmt.Println("Your local changes would be overwritten by checkout:")
+		for _, p := range blocked {
+			fmt.Println("  ", p)
+		}
+		return false
+	}
+	if err := updateWorkingTree(from, to); err != nil {
+		fmt.Println("Error updating working tree:", err)
+		return false
+	}
+	old := currentBranchHead()
+	switchBranch(branch)
+	runHook("post-checkout", hookID(old), hookID(target), "1")
+	return true
+}
+
+/* ----------------------------------------
+   Merging commits into the current branch
+-------------------------------------------*/
+
+func mergeInProgress() bool {
+	_, err := os.Stat(MERGE_HEAD_FILE)
+	return err == nil
+}
+
+func loadMergeConflicts() []string {
+	var conflicts []string
+	data, err := os.ReadFile(MERGE_CONFLICTS_FILE)
+	if err == nil {
+		json.Unmarshal(data, &conflicts)
+	}
+	return conflicts
+}
+
+func clearMergeState() {
+	os.Remove(MERGE_HEAD_FILE)
+	os.Remove(MERGE_MSG_FILE)
+	os.Remove(MERGE_CONFLICTS_FILE)
+}
+
+// integrateCommit brings theirs into the current branch: nothing if it is
+// already contained, a fast-forward when possible, otherwise a rebase or a
+// merge commit. It returns false when the operation stopped or failed.
+// Without verify a merge commit skips the pre-commit and commit-msg hooks.
+func integrateCommit(theirs, label, msg string, rebase, ffOnly, verify bool) bool {
+	if mergeInProgress() {
+		fmt.Println("A merge is in progress; commit the result or run 'gud merge --abort'.")
+		return false
+	}
+	branch := currentBranch()
+	head := currentBranchHead()
+
+	if head == theirs || (head != "" && isAncestor(theirs, head)) {
+		fmt.Println("Already up to date.")
+		return true
+	}
+
+	if head == "" || isAncestor(head, theirs) {
+		from := commitFiles(head)
+		to := commitFiles(theirs)
+		if blocked := blockedPaths(from, to); len(blocked) > 0 {
+			fmt.Println("Your local changes would be overwritten:")
+			for _, p := range blocked {
+				fmt.Println("  ", p)
+			}
+			return false
+		}
+		if err := updateWorkingTree(from, to); err != nil {
+			fmt.Println("Error updating working tree:", err)
+			return false
+		}
+		updateBranch(branch, theirs, "merge "+label+": Fast-forward")
+		fmt.Printf("Fast-forward %s..%s\n", shortID(head), shortID(theirs))
+		return true
+	}
+
+	if ffOnly {
+		fmt.Println("Not possible to fast-forward, aborting.")
+		return false
+	}
+	if rebase {
+		return rebaseCurrentBranch(theirs)
+	}
+	return mergeCommitInto(theirs, label, msg, verify)
+}
+
+// mergeCommitInto performs a three-way merge of theirs into HEAD. A clean
+// merge is committed immediately; conflicts are written to the working tree
+// and the merge is concluded by a later "gud commit", as it is when a hook
+// refuses the merge commit.
+func mergeCommitInto(theirs, label, msg string, verify bool) bool {
+	if len(loadStaging()) > 0 || len(loadStagedRemovals()) > 0 {
+		fmt.Println("You have staged changes; commit or unstage them before merging.")
+		return false
+	}
+	head := currentBranchHead()
+	base := mergeBase(head, theirs)
+	ours := commitFiles(head)
+	result, conflicts := mergeTrees(commitFiles(base), ours, commitFiles(theirs), "HEAD", label)
+
+	if blocked := blockedPaths(ours, result); len(blocked) > 0 {
+		fmt.Println("Your local changes would be overwritten by merge:")
+		for _, p := range blocked {
+			fmt.Println("  ", p)
+		}
+		return false
+	}
+	if err := updateWorkingTree(ours, result); err != nil {
+		fmt.Println("Error updating working tree:", err)
+		return false
+	}
+
+	if len(conflicts) == 0 {
+		if final, ok := prepareCommit(msg, "merge", verify); ok {
+			c, err := commitTree(result, final, []string{head, theirs}, "", configBool("commit.gpgSign", false))
+			if err != nil {
+				fmt.Println("Error writing merge commit:", err)
+				return false
+			}
+			updateBranch(currentBranch(), c.ID, "merge "+label)
+			appendLog(fmt.Sprintf("%s [%s] %s\n", c.ID, c.Branch, subjectLine(final)))
+			fmt.Println("Merge made:", c.ID)
+			return true
+		}
+	}
+
+	// Stage everything that merged cleanly; conflicted files must be
+	// resolved and added by the user before committing.
+	conflicted := make(map[string]bool)
+	for _, p := range conflicts {
+		conflicted[p] = true
+	}
+	staged := make(map[string]string)
+	var removed []string
+	for p, content := range result {
+		if old, ok := ours[p]; (!ok || old != content) && !conflicted[p] {
+			staged[p] = content
+		}
+	}
+	for p := range ours {
+		if _, ok := result[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	saveStaging(staged)
+	saveStagedRemovals(removed)
+
+	os.WriteFile(MERGE_HEAD_FILE, []byte(theirs), 0644)
+	os.WriteFile(MERGE_MSG_FILE, []byte(msg), 0644)
+	data, _ := json.MarshalIndent(conflicts, "", "  ")
+	os.WriteFile(MERGE_CONFLICTS_FILE, data, 0644)
+
+	if len(conflicts) == 0 {
+		fmt.Println("Merge not committed; the result is staged, conclude it with 'gud commit'.")
+		return false
+	}
+	fmt.Println("Automatic merge failed; fix conflicts, 'gud add' them and then 'gud commit':")
+	for _, p := range conflicts {
+		fmt.Println("  CONFLICT", p)
+	}
+	return false
+}
+
+func abortMerge() {
+	if !mergeInProgress() {
+		fmt.Println("No merge in progress.")
+		return
+	}
+	head := commitFiles(currentBranchHead())
+	conflicts := loadMergeConflicts()
+	touched := make(map[string]bool)
+	for p := range loadStaging() {
+		touched[p] = true
+	}
+	for _, p := range append(conflicts, loadStagedRemovals()...) {
+		touched[p] = true
+	}
+	for p := range touched {
+		if content, ok := head[p]; ok {
+			writeWorkingFile(p, content)
+		} else {
+			os.Remove(p)
+		}
+	}
+	saveStaging(map[string]string{})
+	saveStagedRemovals(nil)
+	clearMergeState()
+	fmt.Println("Merge aborted.")
+}
+
+/* ----------------------------------------
+   Replaying commits (rebase)
+-------------------------------------------*/
+
+// commitsToReplay lists, oldest first, the first-parent commits of head that
+// are not contained in upstream. Merge commits are skipped.
+func commitsToReplay(upstream, head string) []*Commit {
+	contained := reachableCommitsAt(GUD_DIR, []string{upstream}, nil)
+	var list []*Commit
+	for id := head; id != "" && !contained[id]; {
+		c, err := readCommit(id)
+		if err != nil {
+			break
+		}
+		if len(c.Parents) <= 1 {
+			list = append(list, c)
+		}
+		id = ""
+		if len(c.Parents) > 0 {
+			id = c.Parents[0]
+		}
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// replayCommits applies each commit's change on top of onto and returns the
+// new tip. It stops without side effects on refs if a commit conflicts.
+func replayCommits(commits []*Commit, onto string) (string, error) {
+	tip := onto
+	for _, c := range commits {
+		parent := ""
+		if len(c.Parents) > 0 {
+			parent = c.Parents[0]
+		}
+		result, conflicts := mergeTrees(commitFiles(parent), commitFiles(tip), c.Files, "HEAD", shortID(c.ID))
+		if len(conflicts) > 0 {
+			return "", fmt.Errorf("conflict replaying %s (%s) in %s", shortID(c.ID), c.Message, strings.Join(conflicts, ", "))
+		}
+		nc, err := commitTree(result, c.Message, []string{tip}, c.Author, configBool("commit.gpgSign", false))
+		if err != nil {
+			return "", err
+		}
+		tip = nc.ID
+	}
+	return tip, nil
+}
+
+// rebaseCurrentBranch replays the current branch's own commits onto upstream.
+func rebaseCurrentBranch(upstream string) bool {
+	if len(loadStaging()) > 0 || len(loadStagedRemovals()) > 0 {
+		fmt.Println("You have staged changes; commit or unstage them before rebasing.")
+		return false
+	}
+	head := currentBranchHead()
+	tip, err := replayCommits(commitsToReplay(upstream, head), upstream)
+	if err != nil {
+		fmt.Println("Rebase failed:", err)
+		fmt.Println("Nothing was changed; merge instead or resolve the conflict manually.")
+		return false
+	}
+	from, to := commitFiles(head), commitFiles(tip)
+	if blocked := blockedPaths(from, to); len(blocked) > 0 {
+		fmt.Println("Your local changes would be overwritten by rebase:")
+		for _, p := range blocked {
+			fmt.Println("  ", p)
+		}
+		return false
+	}
+	if err := updateWorkingTree(from, to); err != nil {
+		fmt.Println("Error updating working tree:", err)
+		return false
+	}
+	updateBranch(currentBranch(), tip, "rebase: onto "+upstream)
+	fmt.Printf("Rebased %s onto %s\n", currentBranch(), shortID(upstream))
+	return true
+}
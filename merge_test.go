@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// checkEditScript verifies that ops turns a into b and returns how many
+// lines it inserts or deletes.
+func checkEditScript(t *testing.T, a, b []string, ops []diffOp) int {
+	t.Helper()
+	edits, i, j := 0, 0, 0
+	for _, op := range ops {
+		switch op.Kind {
+		case ' ':
+			if op.A != i || op.B != j || a[i] != b[j] {
+				t.Fatalf("bad context op %+v at a[%d] b[%d]", op, i, j)
+			}
+			i, j = i+1, j+1
+		case '-':
+			if op.A != i || op.B != j {
+				t.Fatalf("bad deletion %+v at a[%d] b[%d]", op, i, j)
+			}
+			i, edits = i+1, edits+1
+		case '+':
+			if op.A != i || op.B != j {
+				t.Fatalf("bad insertion %+v at a[%d] b[%d]", op, i, j)
+			}
+			j, edits = j+1, edits+1
+		}
+	}
+	if i != len(a) || j != len(b) {
+		t.Fatalf("script stops at a[%d] b[%d], want a[%d] b[%d]", i, j, len(a), len(b))
+	}
+	return edits
+}
+
+// lcsLength is the textbook dynamic program, for checking minimality.
+func lcsLength(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	lines := func(n, alphabet int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = fmt.Sprintf("%d\n", rng.Intn(alphabet))
+		}
+		return out
+	}
+	for iter := 0; iter < 500; iter++ {
+		a, b := lines(rng.Intn(30), 1+rng.Intn(6)), lines(rng.Intn(30), 1+rng.Intn(6))
+		ops := diffLines(a, b)
+		edits := checkEditScript(t, a, b, ops)
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("diff of %q and %q makes %d edits, want %d", a, b, edits, want)
+		}
+		// Within a change, deletions come first.
+		for i := 1; i < len(ops); i++ {
+			if ops[i-1].Kind == '+' && ops[i].Kind == '-' {
+				t.Fatalf("insertion before deletion in %v", ops)
+			}
+		}
+	}
+}
+
+// A quadratic-memory diff needs gigabytes for these.
+func TestDiffLinesOfLargelyDifferentFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		shared int // every shared-th line is the same in both files
+		edits  int
+	}{
+		{"unrelated", 0, 40000},
+		{"few lines in common", 1000, 40000 - 2*20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := make([]string, 20000), make([]string, 20000)
+			for i := range a {
+				a[i] = fmt.Sprintf("old %d\n", i)
+				b[i] = fmt.Sprintf("new %d\n", i)
+				if tt.shared > 0 && i%tt.shared == 0 {
+					a[i], b[i] = "same\n", "same\n"
+				}
+			}
+			// Past MYERS_MAX_COST the script need not be minimal.
+			if edits := checkEditScript(t, a, b, diffLines(a, b)); edits < tt.edits || edits > 40000 {
+				t.Errorf("%d edits, want between %d and 40000", edits, tt.edits)
+			}
+		})
+	}
+}
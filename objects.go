@@ -0,0 +1,546 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   Commit object access and history traversal
+-------------------------------------------*/
+
+func commitsDirAt(gudDir string) string {
+	return filepath.Join(gudDir, "commits")
+}
+
+func tagObjectsDirAt(gudDir string) string {
+	return filepath.Join(gudDir, "tag_objects")
+}
+
+// looseObjectDirAt is where loose objects of a kind are stored.
+func looseObjectDirAt(gudDir string, kind byte) string {
+	if kind == OBJ_TAG {
+		return tagObjectsDirAt(gudDir)
+	}
+	return commitsDirAt(gudDir)
+}
+
+func validObjectID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\.")
+}
+
+// looseObjectPathAt finds a loose object and reports its kind.
+func looseObjectPathAt(gudDir, id string) (string, byte, bool) {
+	if !validObjectID(id) {
+		return "", 0, false
+	}
+	for _, kind := range []byte{OBJ_COMMIT, OBJ_TAG} {
+		path := filepath.Join(looseObjectDirAt(gudDir, kind), id+".json")
+		if _, err := os.Stat(path); err == nil {
+			return path, kind, true
+		}
+	}
+	return "", 0, false
+}
+
+// looseIDsIn lists the objects stored as <id>.json in dir.
+func looseIDsIn(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	return ids
+}
+
+// looseObjectIDsAt lists every loose object of any kind.
+func looseObjectIDsAt(gudDir string) []string {
+	return append(looseIDsIn(commitsDirAt(gudDir)), looseIDsIn(tagObjectsDirAt(gudDir))...)
+}
+
+// readObjectAt returns the kind and raw JSON of a stored object, whether
+// loose or packed.
+func readObjectAt(gudDir, id string) (byte, []byte, error) {
+	if !validObjectID(id) {
+		return 0, nil, fmt.Errorf("invalid object id: %q", id)
+	}
+	if path, kind, ok := looseObjectPathAt(gudDir, id); ok {
+		data, err := os.ReadFile(path)
+		return kind, data, err
+	}
+	obj, found, err := readPackedObjectAt(gudDir, id)
+	if !found {
+		return 0, nil, fmt.Errorf("object not found: %s", id)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return obj.Kind, obj.Data, nil
+}
+
+// objectKindAt reports whether an object is stored and what kind it is.
+func objectKindAt(gudDir, id string) (byte, bool) {
+	if !validObjectID(id) {
+		return 0, false
+	}
+	if _, kind, ok := looseObjectPathAt(gudDir, id); ok {
+		return kind, true
+	}
+	return packedObjectKindAt(gudDir, id)
+}
+
+func hasObjectAt(gudDir, id string) bool {
+	_, ok := objectKindAt(gudDir, id)
+	return ok
+}
+
+func readCommitAt(gudDir, id string) (*Commit, error) {
+	kind, data, err := readObjectAt(gudDir, id)
+	if err != nil || kind != OBJ_COMMIT {
+		return nil, fmt.Errorf("commit not found: %s", id)
+	}
+	return decodeCommit(id, data)
+}
+
+// decodeCommit parses stored commit JSON, rejecting data that does not
+// decode or that carries a different ID than it was stored under.
+func decodeCommit(id string, data []byte) (*Commit, error) {
+	var c Commit
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("corrupt commit %s: %v", id, err)
+	}
+	if c.ID != id {
+		return nil, fmt.Errorf("commit %s has mismatched id %q", id, c.ID)
+	}
+	return &c, nil
+}
+
+// commitHash is the content address of a commit: the SHA-1 of its JSON
+// encoding with the ID left empty.
+func commitHash(c *Commit) string {
+	payload := *c
+	payload.ID = ""
+	data, _ := json.Marshal(&payload)
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+// isContentHashID tells content-addressed IDs apart from the time-based IDs
+// of commits made by earlier versions, which cannot be verified.
+func isContentHashID(id string) bool {
+	if len(id) != 2*sha1.Size {
+		return false
+	}
+	for _, r := range id {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyCommitData decodes a commit and, for content-addressed IDs, checks
+// that the content still hashes to its ID.
+func verifyCommitData(id string, data []byte) (*Commit, error) {
+	c, err := decodeCommit(id, data)
+	if err != nil {
+		return nil, err
+	}
+	if isContentHashID(id) && commitHash(c) != id {
+		return nil, fmt.Errorf("commit %s: hash mismatch (content hashes to %s)", id, commitHash(c))
+	}
+	return c, nil
+}
+
+func readCommit(id string) (*Commit, error) {
+	return readCommitAt(GUD_DIR, id)
+}
+
+func hasCommitAt(gudDir, id string) bool {
+	kind, ok := objectKindAt(gudDir, id)
+	return ok && kind == OBJ_COMMIT
+}
+
+func hasCommit(id string) bool {
+	return hasCommitAt(GUD_DIR, id)
+}
+
+func writeCommit(c *Commit) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(COMMITS_DIR, 0755)
+	return os.WriteFile(filepath.Join(COMMITS_DIR, c.ID+".json"), data, 0644)
+}
+
+// commitTree records a snapshot as a new commit on the current branch, named
+// by its content hash and optionally signed. The current user is the
+// committer and, unless another author is given, the author. It does not
+// move any ref.
+func commitTree(files map[string]string, msg string, parents []string, author string, sign bool) (*Commit, error) {
+	return commitTreeDated(files, msg, parents, author, "", sign)
+}
+
+// commitTreeDated is commitTree with the commit's date given as RFC 3339,
+// for commits that carry over an author's date; "" means now.
+func commitTreeDated(files map[string]string, msg string, parents []string, author, date string, sign bool) (*Commit, error) {
+	committer := userIdentity()
+	if author == "" {
+		author = committer
+	}
+	if date == "" {
+		date = time.Now().Format(time.RFC3339)
+	}
+	c := &Commit{
+		Message:   msg,
+		Timestamp: date,
+		Files:     files,
+		Branch:    currentBranch(),
+		Author:    author,
+		Committer: committer,
+		Parents:   parents,
+	}
+	if sign {
+		if err := signCommit(c); err != nil {
+			return nil, err
+		}
+	}
+	c.ID = commitHash(c)
+	if err := writeCommit(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// commitFiles returns the snapshot of a commit, or an empty snapshot for "".
+func commitFiles(id string) map[string]string {
+	if id == "" {
+		return map[string]string{}
+	}
+	c, err := readCommit(id)
+	if err != nil || c.Files == nil {
+		return map[string]string{}
+	}
+	return c.Files
+}
+
+// allCommitIDsAt lists every stored commit, loose or packed, once.
+func allCommitIDsAt(gudDir string) []string {
+	ids := looseIDsIn(commitsDirAt(gudDir))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, idx := range loadPackIndexesAt(gudDir) {
+		for i, id := range idx.ids {
+			if idx.kinds[i] == OBJ_COMMIT && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// reachableCommitsAt walks parents from heads and returns every commit found,
+// skipping any listed in stop. Missing parents are ignored.
+func reachableCommitsAt(gudDir string, heads []string, stop map[string]bool) map[string]bool {
+	seen := make(map[string]bool)
+	queue := append([]string(nil), heads...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == "" || seen[id] || stop[id] {
+			continue
+		}
+		c, err := readCommitAt(gudDir, id)
+		if err != nil {
+			continue
+		}
+		seen[id] = true
+		queue = append(queue, c.Parents...)
+	}
+	return seen
+}
+
+// commitsWithinDepthAt is like reachableCommitsAt but only follows parents
+// until depth commits have been taken along each path.
+func commitsWithinDepthAt(gudDir string, heads []string, stop map[string]bool, depth int) map[string]bool {
+	type item struct {
+		id    string
+		level int
+	}
+	seen := make(map[string]bool)
+	var queue []item
+	for _, h := range heads {
+		queue = append(queue, item{h, 1})
+	}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		if it.id == "" || seen[it.id] || stop[it.id] || it.level > depth {
+			continue
+		}
+		c, err := readCommitAt(gudDir, it.id)
+		if err != nil {
+			continue
+		}
+		seen[it.id] = true
+		for _, p := range c.Parents {
+			queue = append(queue, item{p, it.level + 1})
+		}
+	}
+	return seen
+}
+
+// loadShallow returns the commits of a shallow clone whose parents were
+// deliberately not fetched.
+func loadShallow() map[string]bool {
+	return loadShallowAt(GUD_DIR)
+}
+
+func loadShallowAt(gudDir string) map[string]bool {
+	shallow := make(map[string]bool)
+	var ids []string
+	data, err := os.ReadFile(filepath.Join(gudDir, filepath.Base(SHALLOW_FILE)))
+	if err == nil {
+		json.Unmarshal(data, &ids)
+	}
+	for _, id := range ids {
+		shallow[id] = true
+	}
+	return shallow
+}
+
+// recordShallow marks received commits with missing parents as shallow.
+func recordShallow(received []string) {
+	shallow := loadShallow()
+	for _, id := range received {
+		c, err := readCommit(id)
+		if err != nil {
+			continue
+		}
+		for _, p := range c.Parents {
+			if !hasCommit(p) {
+				shallow[id] = true
+			}
+		}
+	}
+	if len(shallow) == 0 {
+		return
+	}
+	var ids []string
+	for id := range shallow {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	data, _ := json.MarshalIndent(ids, "", "  ")
+	os.WriteFile(SHALLOW_FILE, data, 0644)
+}
+
+func isAncestorAt(gudDir, ancestor, descendant string) bool {
+	if ancestor == "" {
+		return true
+	}
+	return reachableCommitsAt(gudDir, []string{descendant}, nil)[ancestor]
+}
+
+func isAncestor(ancestor, descendant string) bool {
+	return isAncestorAt(GUD_DIR, ancestor, descendant)
+}
+
+// mergeBase finds a best common ancestor of a and b: a common ancestor that
+// is not itself an ancestor of another common ancestor. One walk marks what
+// each side reaches, reading every commit once; the ancestors of the common
+// commits are then struck out using the parents already read.
+func mergeBase(a, b string) string {
+	const fromA, fromB = 1, 2
+	type item struct {
+		id   string
+		mark int
+	}
+	marks := make(map[string]int)
+	parents := make(map[string][]string)
+	queue := []item{{a, fromA}, {b, fromB}}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		if it.id == "" || marks[it.id]&it.mark == it.mark {
+			continue
+		}
+		ps, read := parents[it.id]
+		if !read {
+			c, err := readCommit(it.id)
+			if err != nil {
+				continue
+			}
+			ps = c.Parents
+			parents[it.id] = ps
+		}
+		marks[it.id] |= it.mark
+		for _, p := range ps {
+			queue = append(queue, item{p, marks[it.id]})
+		}
+	}
+
+	// Parents of common commits are common too, and never the best.
+	stale := make(map[string]bool)
+	var stack []string
+	for id, m := range marks {
+		if m == fromA|fromB {
+			stack = append(stack, parents[id]...)
+		}
+	}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if stale[id] {
+			continue
+		}
+		stale[id] = true
+		stack = append(stack, parents[id]...)
+	}
+	var best []string
+	for id, m := range marks {
+		if m == fromA|fromB && !stale[id] {
+			best = append(best, id)
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	sort.Strings(best)
+	return best[0]
+}
+
+// resolveRevision turns a branch, tag, HEAD, commit ID or unique ID prefix,
+// optionally followed by ~N and ^N suffixes, into a commit ID.
+func resolveRevision(rev string) (string, error) {
+	name := rev
+	suffix := ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		name, suffix = rev[:i], rev[i:]
+	}
+
+	id, err := resolveRevisionName(name)
+	if err != nil {
+		return "", err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		n := 1
+		j := 0
+		for j < len(suffix) && suffix[j] >= '0' && suffix[j] <= '9' {
+			j++
+		}
+		if j > 0 {
+			n, _ = strconv.Atoi(suffix[:j])
+			suffix = suffix[j:]
+		}
+		c, err := readCommit(id)
+		if err != nil {
+			return "", err
+		}
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+			if n > len(c.Parents) {
+				return "", fmt.Errorf("revision %s has no parent %d", rev, n)
+			}
+			id = c.Parents[n-1]
+			continue
+		}
+		for ; n > 0; n-- {
+			if len(c.Parents) == 0 {
+				return "", fmt.Errorf("revision %s goes past the root commit", rev)
+			}
+			id = c.Parents[0]
+			if n > 1 {
+				if c, err = readCommit(id); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	return id, nil
+}
+
+func resolveRevisionName(name string) (string, error) {
+	if name == "HEAD" || name == "@" || name == "" {
+		head := currentBranchHead()
+		if head == "" {
+			return "", fmt.Errorf("HEAD has no commits yet")
+		}
+		return head, nil
+	}
+	if id, ok := loadBranches()[name]; ok && id != "" {
+		return id, nil
+	}
+	if id, ok := loadTags()[name]; ok {
+		return peelTag(id), nil
+	}
+	if id, ok := remoteTrackingRef(name); ok {
+		return id, nil
+	}
+	if id, ok := stashRevision(name); ok {
+		return id, nil
+	}
+	if id, ok, err := reflogRevision(name); ok {
+		return id, err
+	}
+	if hasCommit(name) {
+		return name, nil
+	}
+	if len(name) >= 4 {
+		var matches []string
+		for _, id := range allCommitIDsAt(GUD_DIR) {
+			if strings.HasPrefix(id, name) {
+				matches = append(matches, id)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("ambiguous revision: %s", name)
+		}
+	}
+	return "", fmt.Errorf("unknown revision: %s", name)
+}
+
+// shortID abbreviates a commit ID for display without panicking on short IDs.
+func shortID(id string) string {
+	if len(id) > 7 {
+		return id[:7]
+	}
+	return id
+}
+
+// subjectLine returns the first line of a commit message.
+func subjectLine(msg string) string {
+	return strings.SplitN(msg, "\n", 2)[0]
+}
+
+// updateBranch moves a branch and records why in its reflog.
+func updateBranch(branch, id, reason string) {
+	branches := loadBranches()
+	old := branches[branch]
+	branches[branch] = id
+	saveBranches(branches)
+	logBranchUpdateAt(GUD_DIR, branch, old, id, reason)
+}
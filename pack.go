@@ -0,0 +1,761 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* ----------------------------------------
+   Packfiles: zlib-compressed, delta-encoded object bundles
+-------------------------------------------*/
+
+// A pack is "GPAK", a version and an object count, followed by one entry per
+// object and a SHA-1 of everything before it. Each entry is:
+//
+//	kind byte | delta flag byte | uvarint len + id | [uvarint len + base id]
+//	| uvarint len + zlib(data or delta)
+//
+// Deltas always refer to an object stored earlier in the same pack. The
+// matching .idx file lists the sorted object IDs with their kinds and pack
+// offsets so single objects can be read without scanning the pack. Version 1
+// indexes predate tag objects and have no kinds; all their objects are
+// commits.
+
+const (
+	PACK_DIR        = ".gud/objects/pack"
+	PACK_VERSION    = 1
+	INDEX_VERSION   = 2
+	OBJ_COMMIT      = 1
+	OBJ_TAG         = 2
+	MAX_DELTA_CHAIN = 50
+	DELTA_BLOCK     = 16
+	MAX_OBJECT_SIZE = 1 << 30 // packs come from other repositories; refuse absurd sizes
+)
+
+var (
+	packMagic  = []byte("GPAK")
+	indexMagic = []byte("GIDX")
+)
+
+func packDirAt(gudDir string) string {
+	return filepath.Join(gudDir, "objects", "pack")
+}
+
+type packObject struct {
+	ID   string
+	Kind byte
+	Data []byte
+}
+
+// packLocation is where an object lives in a pack.
+type packLocation struct {
+	Offset uint64
+	Kind   byte
+}
+
+type packIndex struct {
+	packPath string
+	ids      []string
+	offsets  []uint64
+	kinds    []byte
+	data     []byte // pack contents, loaded on first use
+}
+
+// packIndexCache holds parsed pack indexes by path. Packs are named after
+// their checksum, so a path always holds the same pack; the directory is
+// listed again on every load to see packs that other processes (a push to a
+// running server, a gc) have added or removed since.
+var (
+	packIndexMu    sync.Mutex
+	packIndexCache = make(map[string]*packIndex)
+)
+
+func loadPackIndexesAt(gudDir string) []*packIndex {
+	dir := packDirAt(gudDir)
+	paths, _ := filepath.Glob(filepath.Join(dir, "pack-*.idx"))
+	sort.Strings(paths)
+
+	packIndexMu.Lock()
+	defer packIndexMu.Unlock()
+	present := make(map[string]bool)
+	var idxs []*packIndex
+	for _, p := range paths {
+		present[p] = true
+		idx, ok := packIndexCache[p]
+		if !ok {
+			var err error
+			if idx, err = readPackIndex(p); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning: ignoring unreadable pack index:", p, err)
+				continue
+			}
+			packIndexCache[p] = idx
+		}
+		idxs = append(idxs, idx)
+	}
+	for p := range packIndexCache {
+		if filepath.Dir(p) == dir && !present[p] {
+			delete(packIndexCache, p)
+		}
+	}
+	return idxs
+}
+
+func readPackIndex(path string) (*packIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(indexMagic)+sha1.Size || !bytes.Equal(data[:len(indexMagic)], indexMagic) {
+		return nil, fmt.Errorf("not a pack index")
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if h := sha1.Sum(body); !bytes.Equal(h[:], sum) {
+		return nil, fmt.Errorf("index checksum mismatch")
+	}
+	r := bytes.NewReader(body[len(indexMagic):])
+	var version, count uint32
+	binary.Read(r, binary.BigEndian, &version)
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil || version < 1 || version > INDEX_VERSION {
+		return nil, fmt.Errorf("unsupported index version")
+	}
+	idx := &packIndex{packPath: strings.TrimSuffix(path, ".idx") + ".pack"}
+	for i := uint32(0); i < count; i++ {
+		id, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		kind := byte(OBJ_COMMIT)
+		if version >= 2 {
+			if kind, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		var off uint64
+		if err := binary.Read(r, binary.BigEndian, &off); err != nil {
+			return nil, err
+		}
+		idx.ids = append(idx.ids, id)
+		idx.kinds = append(idx.kinds, kind)
+		idx.offsets = append(idx.offsets, off)
+	}
+	return idx, nil
+}
+
+func (idx *packIndex) find(id string) (uint64, bool) {
+	i := sort.SearchStrings(idx.ids, id)
+	if i < len(idx.ids) && idx.ids[i] == id {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
+
+func (idx *packIndex) kind(id string) (byte, bool) {
+	i := sort.SearchStrings(idx.ids, id)
+	if i < len(idx.ids) && idx.ids[i] == id {
+		return idx.kinds[i], true
+	}
+	return 0, false
+}
+
+func (idx *packIndex) readObject(id string, depth int) (packObject, error) {
+	if depth > MAX_DELTA_CHAIN+1 {
+		return packObject{}, fmt.Errorf("delta chain too deep at %s", id)
+	}
+	off, ok := idx.find(id)
+	if !ok {
+		return packObject{}, fmt.Errorf("object %s not in pack", id)
+	}
+	data, err := idx.contents()
+	if err != nil {
+		return packObject{}, err
+	}
+	if off >= uint64(len(data)) {
+		return packObject{}, fmt.Errorf("bad offset for %s", id)
+	}
+	r := bytes.NewReader(data[off:])
+	e, err := readPackEntry(r)
+	if err != nil {
+		return packObject{}, err
+	}
+	if e.id != id {
+		return packObject{}, fmt.Errorf("pack entry mismatch: wanted %s, found %s", id, e.id)
+	}
+	if e.base == "" {
+		return packObject{id, e.kind, e.payload}, nil
+	}
+	base, err := idx.readObject(e.base, depth+1)
+	if err != nil {
+		return packObject{}, err
+	}
+	resolved, err := applyDelta(base.Data, e.payload)
+	if err != nil {
+		return packObject{}, fmt.Errorf("object %s: %v", id, err)
+	}
+	return packObject{id, e.kind, resolved}, nil
+}
+
+// contents reads the pack on first use. Indexes are shared between the
+// requests of a server, hence the lock.
+func (idx *packIndex) contents() ([]byte, error) {
+	packIndexMu.Lock()
+	defer packIndexMu.Unlock()
+	if idx.data == nil {
+		data, err := os.ReadFile(idx.packPath)
+		if err != nil {
+			return nil, err
+		}
+		idx.data = data
+	}
+	return idx.data, nil
+}
+
+// readPackedObjectAt looks an object up in every pack of a repository.
+func readPackedObjectAt(gudDir, id string) (packObject, bool, error) {
+	for _, idx := range loadPackIndexesAt(gudDir) {
+		if _, ok := idx.find(id); ok {
+			obj, err := idx.readObject(id, 0)
+			return obj, true, err
+		}
+	}
+	return packObject{}, false, nil
+}
+
+// packedObjectKindAt reports the kind of a packed object.
+func packedObjectKindAt(gudDir, id string) (byte, bool) {
+	for _, idx := range loadPackIndexesAt(gudDir) {
+		if kind, ok := idx.kind(id); ok {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+func packedObjectIDsAt(gudDir string) []string {
+	var ids []string
+	for _, idx := range loadPackIndexesAt(gudDir) {
+		ids = append(ids, idx.ids...)
+	}
+	return ids
+}
+
+/* ----------------------------------------
+   Encoding and decoding packs
+-------------------------------------------*/
+
+func writeString(w io.Writer, s string) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(s)))
+	w.Write(buf[:n])
+	io.WriteString(w, s)
+}
+
+func readString(r io.ByteReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<16 {
+		return "", fmt.Errorf("string too long")
+	}
+	b := make([]byte, n)
+	for i := range b {
+		if b[i], err = r.ReadByte(); err != nil {
+			return "", err
+		}
+	}
+	return string(b), nil
+}
+
+func writeUvarint(w io.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+type rawPackEntry struct {
+	kind    byte
+	id      string
+	base    string
+	payload []byte
+}
+
+func readPackEntry(r *bytes.Reader) (rawPackEntry, error) {
+	var e rawPackEntry
+	var err error
+	if e.kind, err = r.ReadByte(); err != nil {
+		return e, err
+	}
+	isDelta, err := r.ReadByte()
+	if err != nil {
+		return e, err
+	}
+	if e.id, err = readString(r); err != nil {
+		return e, err
+	}
+	if isDelta == 1 {
+		if e.base, err = readString(r); err != nil {
+			return e, err
+		}
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return e, err
+	}
+	if size > uint64(r.Len()) {
+		return e, fmt.Errorf("truncated pack entry %s", e.id)
+	}
+	compressed := make([]byte, size)
+	io.ReadFull(r, compressed)
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return e, fmt.Errorf("entry %s: %v", e.id, err)
+	}
+	defer zr.Close()
+	if e.payload, err = io.ReadAll(io.LimitReader(zr, MAX_OBJECT_SIZE+1)); err != nil {
+		return e, fmt.Errorf("entry %s: %v", e.id, err)
+	}
+	if len(e.payload) > MAX_OBJECT_SIZE {
+		return e, fmt.Errorf("entry %s is larger than %d bytes", e.id, MAX_OBJECT_SIZE)
+	}
+	return e, nil
+}
+
+// objectParents extracts delta base candidates: a commit's parents are
+// usually the most similar objects.
+func objectParents(obj packObject) []string {
+	var c struct {
+		Parents []string `json:"parents"`
+	}
+	json.Unmarshal(obj.Data, &c)
+	return c.Parents
+}
+
+// encodePack bundles objects, delta-encoding each one against its first
+// parent when that parent is in the same pack and the delta is smaller.
+func encodePack(objects []packObject) ([]byte, map[string]packLocation) {
+	byID := make(map[string]packObject)
+	for _, o := range objects {
+		byID[o.ID] = o
+	}
+	ids := make([]string, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	buf.Write(packMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(PACK_VERSION))
+	binary.Write(&buf, binary.BigEndian, uint32(len(ids)))
+
+	offsets := make(map[string]packLocation)
+	chain := make(map[string]int)
+	var emit func(id string)
+	emit = func(id string) {
+		if _, done := offsets[id]; done {
+			return
+		}
+		obj := byID[id]
+		base := ""
+		for _, p := range objectParents(obj) {
+			if _, ok := byID[p]; ok {
+				base = p
+				break
+			}
+		}
+		payload := obj.Data
+		if base != "" {
+			// Reserve the slot so cycles in bad data cannot recurse forever.
+			offsets[id] = packLocation{}
+			emit(base)
+			delete(offsets, id)
+			if chain[base] < MAX_DELTA_CHAIN {
+				if delta := makeDelta(byID[base].Data, obj.Data); len(delta) < len(obj.Data)/2 {
+					payload = delta
+					chain[id] = chain[base] + 1
+				} else {
+					base = ""
+				}
+			} else {
+				base = ""
+			}
+		}
+
+		offsets[id] = packLocation{uint64(buf.Len()), obj.Kind}
+		buf.WriteByte(obj.Kind)
+		if base != "" {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		writeString(&buf, id)
+		if base != "" {
+			writeString(&buf, base)
+		}
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(payload)
+		zw.Close()
+		writeUvarint(&buf, uint64(z.Len()))
+		buf.Write(z.Bytes())
+	}
+	for _, id := range ids {
+		emit(id)
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), offsets
+}
+
+// decodePack verifies a pack and returns its fully resolved objects along
+// with their locations.
+func decodePack(data []byte) ([]packObject, map[string]packLocation, error) {
+	if len(data) < len(packMagic)+8+sha1.Size || !bytes.Equal(data[:len(packMagic)], packMagic) {
+		return nil, nil, fmt.Errorf("not a pack")
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if h := sha1.Sum(body); !bytes.Equal(h[:], sum) {
+		return nil, nil, fmt.Errorf("pack checksum mismatch")
+	}
+	r := bytes.NewReader(body[len(packMagic):])
+	var version, count uint32
+	binary.Read(r, binary.BigEndian, &version)
+	binary.Read(r, binary.BigEndian, &count)
+	if version != PACK_VERSION {
+		return nil, nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+
+	resolved := make(map[string][]byte)
+	offsets := make(map[string]packLocation)
+	var objects []packObject
+	for i := uint32(0); i < count; i++ {
+		off := uint64(len(body) - r.Len())
+		e, err := readPackEntry(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		content := e.payload
+		if e.base != "" {
+			base, ok := resolved[e.base]
+			if !ok {
+				return nil, nil, fmt.Errorf("delta base %s of %s not in pack", e.base, e.id)
+			}
+			if content, err = applyDelta(base, e.payload); err != nil {
+				return nil, nil, fmt.Errorf("object %s: %v", e.id, err)
+			}
+		}
+		resolved[e.id] = content
+		offsets[e.id] = packLocation{off, e.kind}
+		objects = append(objects, packObject{e.id, e.kind, content})
+	}
+	return objects, offsets, nil
+}
+
+func encodeIndex(offsets map[string]packLocation) []byte {
+	ids := make([]string, 0, len(offsets))
+	for id := range offsets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var buf bytes.Buffer
+	buf.Write(indexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(INDEX_VERSION))
+	binary.Write(&buf, binary.BigEndian, uint32(len(ids)))
+	for _, id := range ids {
+		writeString(&buf, id)
+		buf.WriteByte(offsets[id].Kind)
+		binary.Write(&buf, binary.BigEndian, offsets[id].Offset)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// validatePackObject checks that an object decodes and matches its ID.
+func validatePackObject(obj packObject) error {
+	var err error
+	switch obj.Kind {
+	case OBJ_COMMIT:
+		_, err = verifyCommitData(obj.ID, obj.Data)
+	case OBJ_TAG:
+		_, err = verifyTagData(obj.ID, obj.Data)
+	default:
+		err = fmt.Errorf("object %s has unknown kind %d", obj.ID, obj.Kind)
+	}
+	return err
+}
+
+// storePackAt verifies a received pack and installs it with its index. It
+// returns the IDs of the objects the repository did not have before.
+func storePackAt(gudDir string, pack []byte) ([]string, error) {
+	objects, offsets, err := decodePack(pack)
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, o := range objects {
+		if err := validatePackObject(o); err != nil {
+			return nil, err
+		}
+		if !hasObjectAt(gudDir, o.ID) {
+			added = append(added, o.ID)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	if _, err := installPackAt(gudDir, pack, offsets); err != nil {
+		return nil, err
+	}
+	sort.Strings(added)
+	return added, nil
+}
+
+func installPackAt(gudDir string, pack []byte, offsets map[string]packLocation) (string, error) {
+	dir := packDirAt(gudDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("pack-%x", pack[len(pack)-sha1.Size:])
+	packPath := filepath.Join(dir, name+".pack")
+	if err := os.WriteFile(packPath, pack, 0644); err != nil {
+		return "", err
+	}
+	// The index is written last: a pack without an index is simply ignored.
+	if err := os.WriteFile(filepath.Join(dir, name+".idx"), encodeIndex(offsets), 0644); err != nil {
+		return "", err
+	}
+	return packPath, nil
+}
+
+func readPackObjectsAt(gudDir string, ids []string) ([]packObject, error) {
+	var objects []packObject
+	for _, id := range ids {
+		kind, data, err := readObjectAt(gudDir, id)
+		if err != nil {
+			return nil, fmt.Errorf("reading object %s: %v", id, err)
+		}
+		objects = append(objects, packObject{id, kind, data})
+	}
+	return objects, nil
+}
+
+// packObjectsAt builds a pack holding the given objects of a repository.
+func packObjectsAt(gudDir string, ids []string) ([]byte, error) {
+	objects, err := readPackObjectsAt(gudDir, ids)
+	if err != nil {
+		return nil, err
+	}
+	pack, _ := encodePack(objects)
+	return pack, nil
+}
+
+/* ----------------------------------------
+   Delta encoding
+-------------------------------------------*/
+
+// A delta is the base and result sizes followed by instructions: 1 copies
+// (offset, length) from the base, 2 inserts (length) literal bytes.
+
+func makeDelta(base, target []byte) []byte {
+	index := make(map[string]int)
+	for i := 0; i+DELTA_BLOCK <= len(base); i += DELTA_BLOCK {
+		key := string(base[i : i+DELTA_BLOCK])
+		if _, ok := index[key]; !ok {
+			index[key] = i
+		}
+	}
+
+	var out bytes.Buffer
+	writeUvarint(&out, uint64(len(base)))
+	writeUvarint(&out, uint64(len(target)))
+	var pending []byte
+	flush := func() {
+		if len(pending) > 0 {
+			out.WriteByte(2)
+			writeUvarint(&out, uint64(len(pending)))
+			out.Write(pending)
+			pending = nil
+		}
+	}
+
+	for i := 0; i < len(target); {
+		if i+DELTA_BLOCK <= len(target) {
+			if start, ok := index[string(target[i:i+DELTA_BLOCK])]; ok {
+				// Extend the match backwards into pending literals and forwards.
+				for start > 0 && len(pending) > 0 && base[start-1] == pending[len(pending)-1] {
+					start--
+					i--
+					pending = pending[:len(pending)-1]
+				}
+				n := 0
+				for start+n < len(base) && i+n < len(target) && base[start+n] == target[i+n] {
+					n++
+				}
+				flush()
+				out.WriteByte(1)
+				writeUvarint(&out, uint64(start))
+				writeUvarint(&out, uint64(n))
+				i += n
+				continue
+			}
+		}
+		pending = append(pending, target[i])
+		i++
+	}
+	flush()
+	return out.Bytes()
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(delta))
+	baseLen, err := binary.ReadUvarint(r)
+	if err != nil || baseLen != uint64(len(base)) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	targetLen, err := binary.ReadUvarint(r)
+	if err != nil || targetLen > MAX_OBJECT_SIZE {
+		return nil, fmt.Errorf("corrupt delta")
+	}
+	// targetLen comes from the delta, so it only bounds the output; the
+	// capacity is a hint that a corrupt delta cannot inflate.
+	out := make([]byte, 0, min(targetLen, uint64(len(base)+len(delta))))
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		switch op {
+		case 1:
+			off, err1 := binary.ReadUvarint(r)
+			n, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || off > uint64(len(base)) || n > uint64(len(base))-off || n > targetLen-uint64(len(out)) {
+				return nil, fmt.Errorf("corrupt delta copy")
+			}
+			out = append(out, base[off:off+n]...)
+		case 2:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > targetLen-uint64(len(out)) {
+				return nil, fmt.Errorf("corrupt delta insert")
+			}
+			lit := make([]byte, n)
+			if _, err := io.ReadFull(r, lit); err != nil {
+				return nil, fmt.Errorf("corrupt delta insert")
+			}
+			out = append(out, lit...)
+		default:
+			return nil, fmt.Errorf("unknown delta instruction %d", op)
+		}
+	}
+	if uint64(len(out)) != targetLen {
+		return nil, fmt.Errorf("delta result size mismatch")
+	}
+	return out, nil
+}
+
+/* ----------------------------------------
+   gud repack
+-------------------------------------------*/
+
+// writePackAt packs the given objects of a repository into a new pack file
+// and returns its path.
+func writePackAt(gudDir string, ids []string) (string, error) {
+	objects, err := readPackObjectsAt(gudDir, ids)
+	if err != nil {
+		return "", err
+	}
+	pack, offsets := encodePack(objects)
+	return installPackAt(gudDir, pack, offsets)
+}
+
+// removePacksAt deletes the given packs, except the one at keep.
+func removePacksAt(gudDir string, packs []*packIndex, keep string) {
+	for _, idx := range packs {
+		if idx.packPath != keep {
+			os.Remove(strings.TrimSuffix(idx.packPath, ".pack") + ".idx")
+			os.Remove(idx.packPath)
+		}
+	}
+}
+
+// removeLooseAt deletes the loose copies of objects that are now packed.
+func removeLooseAt(gudDir string, ids map[string]bool) {
+	for _, id := range looseObjectIDsAt(gudDir) {
+		if path, _, ok := looseObjectPathAt(gudDir, id); ok && ids[id] {
+			os.Remove(path)
+		}
+	}
+}
+
+// repack writes loose objects (and, with all, the contents of existing
+// packs) into a single new pack. With removeRedundant the loose objects and
+// old packs that are now covered are deleted.
+func repack(all, removeRedundant bool) (int, error) {
+	ids := looseObjectIDsAt(GUD_DIR)
+	oldPacks := loadPackIndexesAt(GUD_DIR)
+	if all {
+		ids = append(ids, packedObjectIDsAt(GUD_DIR)...)
+	}
+	seen := make(map[string]bool)
+	var unique []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return 0, nil
+	}
+
+	newPath, err := writePackAt(GUD_DIR, unique)
+	if err != nil {
+		return 0, err
+	}
+	if removeRedundant {
+		removeLooseAt(GUD_DIR, seen)
+		if all {
+			removePacksAt(GUD_DIR, oldPacks, newPath)
+		}
+	}
+	return len(unique), nil
+}
+
+func repackCommand(args []string) {
+	all, remove := false, false
+	for _, a := range args {
+		switch a {
+		case "-a":
+			all = true
+		case "-d":
+			remove = true
+		case "-ad", "-da":
+			all, remove = true, true
+		default:
+			fmt.Println("Usage: gud repack [-a] [-d]")
+			return
+		}
+	}
+	n, err := repack(all, remove)
+	if err != nil {
+		fmt.Println("Error repacking:", err)
+		return
+	}
+	if n == 0 {
+		fmt.Println("Nothing to pack.")
+		return
+	}
+	fmt.Printf("Packed %d objects.\n", n)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePackFiles installs a pack the way another process would, without
+// going through installPackAt.
+func writePackFiles(t *testing.T, gudDir string, objects []packObject) string {
+	t.Helper()
+	pack, offsets := encodePack(objects)
+	dir := packDirAt(gudDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("pack-%x", pack[len(pack)-sha1.Size:]))
+	if err := os.WriteFile(name+".pack", pack, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name+".idx", encodeIndex(offsets), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestPackIndexCacheSeesOutsideChanges(t *testing.T) {
+	gudDir := t.TempDir()
+	if ids := packedObjectIDsAt(gudDir); len(ids) != 0 {
+		t.Fatalf("empty repository lists packed objects %v", ids)
+	}
+
+	first := writePackFiles(t, gudDir, []packObject{{"a1", OBJ_COMMIT, []byte(`{"message":"one"}`)}})
+	obj, found, err := readPackedObjectAt(gudDir, "a1")
+	if err != nil || !found || string(obj.Data) != `{"message":"one"}` {
+		t.Fatalf("pack written after the first load not seen: found=%v err=%v", found, err)
+	}
+
+	writePackFiles(t, gudDir, []packObject{{"b2", OBJ_TAG, []byte(`{"tag":"v1"}`)}})
+	if kind, ok := packedObjectKindAt(gudDir, "b2"); !ok || kind != OBJ_TAG {
+		t.Fatalf("second pack not seen: kind=%d ok=%v", kind, ok)
+	}
+
+	os.Remove(first + ".idx")
+	os.Remove(first + ".pack")
+	if _, found, _ := readPackedObjectAt(gudDir, "a1"); found {
+		t.Fatal("removed pack is still served from the cache")
+	}
+	if ids := packedObjectIDsAt(gudDir); len(ids) != 1 || ids[0] != "b2" {
+		t.Fatalf("packed objects = %v, want [b2]", ids)
+	}
+}
+
+// commitChain makes n commits, each a small edit of its parent, so that the
+// encoder stores them as chains of deltas.
+func commitChain(n int) []packObject {
+	var objects []packObject
+	files := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("file%02d.txt", i)] = fmt.Sprintf("line of file %d that stays the same in every commit\n", i)
+	}
+	parent := ""
+	for i := 0; i < n; i++ {
+		files["counter.txt"] = fmt.Sprintf("%d\n", i)
+		c := &Commit{Message: fmt.Sprintf("commit %d", i), Timestamp: "2024-01-01T00:00:00Z", Files: copyFiles(files), Author: "A <a@example.com>"}
+		if parent != "" {
+			c.Parents = []string{parent}
+		}
+		c.ID = commitHash(c)
+		data, _ := json.Marshal(c)
+		objects = append(objects, packObject{c.ID, OBJ_COMMIT, data})
+		parent = c.ID
+	}
+	return objects
+}
+
+func writeLooseObjects(t *testing.T, gudDir string, objects []packObject) []string {
+	t.Helper()
+	var ids []string
+	for _, o := range objects {
+		dir := looseObjectDirAt(gudDir, o.Kind)
+		os.MkdirAll(dir, 0755)
+		if err := os.WriteFile(filepath.Join(dir, o.ID+".json"), o.Data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, o.ID)
+	}
+	return ids
+}
+
+// deltaDepths reports how many deltas each entry of a pack sits on.
+func deltaDepths(t *testing.T, pack []byte) map[string]int {
+	t.Helper()
+	r := bytes.NewReader(pack[len(packMagic)+8 : len(pack)-sha1.Size])
+	depth := make(map[string]int)
+	for r.Len() > 0 {
+		e, err := readPackEntry(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.base != "" {
+			depth[e.id] = depth[e.base] + 1
+		}
+	}
+	return depth
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	tag := packObject{"t1", OBJ_TAG, []byte(`{"tag":"v1","object":"x"}`)}
+	tests := []struct {
+		name     string
+		objects  []packObject
+		minDepth int // deepest delta chain expected in the pack
+	}{
+		{"single object", commitChain(1), 0},
+		{"delta on a full object", commitChain(2), 1},
+		{"deltas on deltas", commitChain(10), 9},
+		{"chain capped at MAX_DELTA_CHAIN", commitChain(MAX_DELTA_CHAIN + 5), MAX_DELTA_CHAIN},
+		{"mixed kinds", append(commitChain(3), tag), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gudDir := t.TempDir()
+			ids := writeLooseObjects(t, gudDir, tt.objects)
+			path, err := writePackAt(gudDir, ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			os.RemoveAll(commitsDirAt(gudDir))
+			os.RemoveAll(tagObjectsDirAt(gudDir))
+
+			pack, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deepest := 0
+			for _, d := range deltaDepths(t, pack) {
+				if d > MAX_DELTA_CHAIN {
+					t.Errorf("delta chain of %d exceeds MAX_DELTA_CHAIN", d)
+				}
+				deepest = max(deepest, d)
+			}
+			if deepest < tt.minDepth {
+				t.Errorf("deepest delta chain = %d, want at least %d", deepest, tt.minDepth)
+			}
+
+			for _, o := range tt.objects {
+				kind, data, err := readObjectAt(gudDir, o.ID)
+				if err != nil {
+					t.Fatalf("reading %s: %v", o.ID, err)
+				}
+				if kind != o.Kind || !bytes.Equal(data, o.Data) {
+					t.Errorf("object %s did not round-trip", o.ID)
+				}
+			}
+			decoded, _, err := decodePack(pack)
+			if err != nil || len(decoded) != len(tt.objects) {
+				t.Errorf("decodePack: %d objects, err %v", len(decoded), err)
+			}
+		})
+	}
+}
+
+func TestCorruptPack(t *testing.T) {
+	objects := commitChain(5)
+	good, offsets := encodePack(objects)
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{"empty", func(p []byte) []byte { return nil }},
+		{"header only", func(p []byte) []byte { return p[:len(packMagic)+8] }},
+		{"truncated", func(p []byte) []byte { return p[:len(p)/2] }},
+		{"last entry cut short", func(p []byte) []byte { return p[:len(p)-sha1.Size-10] }},
+		{"flipped byte", func(p []byte) []byte { p[len(p)/2] ^= 0xff; return p }},
+		{"bad magic", func(p []byte) []byte { p[0] = 'X'; return p }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack := tt.corrupt(append([]byte(nil), good...))
+			if _, _, err := decodePack(pack); err == nil {
+				t.Error("decodePack accepted a corrupt pack")
+			}
+
+			// The index still describes the good pack, as after a bad copy.
+			gudDir := t.TempDir()
+			dir := packDirAt(gudDir)
+			os.MkdirAll(dir, 0755)
+			os.WriteFile(filepath.Join(dir, "pack-x.pack"), pack, 0644)
+			os.WriteFile(filepath.Join(dir, "pack-x.idx"), encodeIndex(offsets), 0644)
+			failed := false
+			for _, o := range objects {
+				_, data, err := readObjectAt(gudDir, o.ID)
+				if err != nil {
+					failed = true
+				} else if !bytes.Equal(data, o.Data) {
+					t.Errorf("object %s read back wrong without an error", o.ID)
+				}
+			}
+			if !failed && tt.name != "bad magic" {
+				t.Error("no object read failed")
+			}
+		})
+	}
+}
+
+// uvarints encodes delta fields, so tests can write out-of-range values.
+func uvarints(vs ...uint64) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.AppendUvarint(b, v)
+	}
+	return b
+}
+
+func TestApplyDeltaRejectsCorruptDeltas(t *testing.T) {
+	base := []byte("the quick brown fox jumps over the lazy dog, again and again")
+	target := []byte("the quick brown fox jumps over the lazy cat, again and again!")
+	delta := makeDelta(base, target)
+	if out, err := applyDelta(base, delta); err != nil || !bytes.Equal(out, target) {
+		t.Fatalf("applyDelta(makeDelta) = %q, %v", out, err)
+	}
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+	}{
+		{"wrong base", []byte("short"), delta},
+		{"truncated", base, delta[:len(delta)-3]},
+		{"copy past the base", base, []byte{byte(len(base)), 4, 1, 50, 20}},
+		{"insert longer than target", base, []byte{byte(len(base)), 2, 2, 9, 'a', 'b'}},
+		{"unknown instruction", base, []byte{byte(len(base)), 1, 7}},
+		{"empty", base, nil},
+		{"huge target size", base, uvarints(uint64(len(base)), math.MaxUint64, 1, 0, 1)},
+		{"copy offset wraps around", base, uvarints(uint64(len(base)), 1, 1, math.MaxUint64, 2)},
+		{"copy length wraps around", base, uvarints(uint64(len(base)), 1, 1, 1, math.MaxUint64)},
+		{"copy past the target size", base, uvarints(uint64(len(base)), 4, 1, 0, 10)},
+		{"insert past the target size", base, append(uvarints(uint64(len(base)), 4, 1, 0, 2, 2, 3), "abc"...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := applyDelta(tt.base, tt.delta); err == nil {
+				t.Error("corrupt delta accepted")
+			}
+		})
+	}
+}
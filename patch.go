@@ -0,0 +1,900 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   Patches: format-patch, apply and am
+-------------------------------------------*/
+
+// format-patch writes each commit as an mbox message: a "From <id>" line,
+// From/Date/Subject headers, the commit message, a diffstat and the unified
+// diff. apply applies the diffs of such a file (or of any unified diff) to
+// the working tree and/or the index, and am turns a series of messages back
+// into commits, keeping their authors and dates.
+
+const (
+	AM_FILE         = ".gud/am"
+	MBOX_DATE       = "Mon Sep 17 00:00:00 2001" // fixed, as in git
+	EMAIL_DATE      = "Mon, 2 Jan 2006 15:04:05 -0700"
+	PATCH_SIGNATURE = "gud"
+)
+
+var (
+	mboxFromLine = regexp.MustCompile(`^From [0-9a-f]{40} `)
+	hunkHeader   = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+)
+
+/* ---- format-patch ----*/
+
+func formatPatchUsage() {
+	fmt.Println("Usage: gud format-patch [-o <dir>] [--stdout] (<since> | <A>..<B> | -<n>)")
+}
+
+// formatPatchCommand writes the commits of HEAD that <since> does not have
+// (or of B that A does not have, or the last n) as numbered patch files.
+func formatPatchCommand(args []string) {
+	outDir, stdout := ".", false
+	var revs []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case (a == "-o" || a == "--output-directory") && i+1 < len(args):
+			i++
+			outDir = args[i]
+		case a == "--stdout":
+			stdout = true
+		case len(a) > 1 && a[0] == '-' && a[1] >= '0' && a[1] <= '9':
+			n, err := strconv.Atoi(a[1:])
+			if err != nil {
+				formatPatchUsage()
+				return
+			}
+			revs = append(revs, fmt.Sprintf("HEAD~%d..HEAD", n))
+		case strings.HasPrefix(a, "-"):
+			fmt.Println("Error: unknown option:", a)
+			formatPatchUsage()
+			return
+		default:
+			revs = append(revs, a)
+		}
+	}
+	if len(revs) != 1 {
+		formatPatchUsage()
+		return
+	}
+	commits, err := formatPatchCommits(revs[0])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(commits) == 0 {
+		fmt.Println("No commits to format.")
+		return
+	}
+
+	if !stdout {
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			fmt.Println("Error creating output directory:", err)
+			return
+		}
+	}
+	for n, c := range commits {
+		text := formatPatch(c, n+1, len(commits))
+		if stdout {
+			fmt.Print(text)
+			continue
+		}
+		name := filepath.Join(outDir, fmt.Sprintf("%04d-%s.patch", n+1, patchFileSlug(subjectLine(c.Message))))
+		if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+			fmt.Println("Error writing patch:", err)
+			return
+		}
+		fmt.Println(name)
+	}
+}
+
+// formatPatchCommits lists the commits to export, oldest first. A range
+// whose start goes past the root commit starts at the root.
+func formatPatchCommits(rev string) ([]*Commit, error) {
+	from, to := rev, "HEAD"
+	if i := strings.Index(rev, ".."); i >= 0 {
+		from, to = rev[:i], rev[i+2:]
+		if to == "" {
+			to = "HEAD"
+		}
+	}
+	head, err := resolveRevision(to)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := resolveRevision(from)
+	if err != nil {
+		if !strings.Contains(err.Error(), "past the root") {
+			return nil, err
+		}
+		upstream = ""
+	}
+	return commitsToReplay(upstream, head), nil
+}
+
+// patchFileSlug turns a subject into a file name part: letters and digits
+// joined by single dashes.
+func patchFileSlug(subject string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range subject {
+		if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	slug := strings.Trim(b.String(), ".")
+	if len(slug) > 52 {
+		slug = strings.TrimRight(slug[:52], "-.")
+	}
+	if slug == "" {
+		slug = "patch"
+	}
+	return slug
+}
+
+// formatPatch renders one commit as an mbox message.
+func formatPatch(c *Commit, n, total int) string {
+	var parent map[string]string
+	if len(c.Parents) > 0 {
+		parent = commitFiles(c.Parents[0])
+	}
+	opts := defaultDiffOptions()
+
+	prefix := "[PATCH]"
+	if total > 1 {
+		prefix = fmt.Sprintf("[PATCH %d/%d]", n, total)
+	}
+	date := c.Timestamp
+	if t, err := time.Parse(time.RFC3339, c.Timestamp); err == nil {
+		date = t.Format(EMAIL_DATE)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s %s\n", c.ID, MBOX_DATE)
+	fmt.Fprintf(&b, "From: %s\n", c.Author)
+	fmt.Fprintf(&b, "Date: %s\n", date)
+	fmt.Fprintf(&b, "Subject: %s %s\n\n", prefix, subjectLine(c.Message))
+	if body := messageBody(c.Message); body != "" {
+		b.WriteString(body + "\n\n")
+	}
+	b.WriteString("---\n")
+	b.WriteString(diffSummary("stat", opts, parent, c.Files))
+	b.WriteString("\n")
+	b.WriteString(treePatch(parent, c.Files, opts))
+	fmt.Fprintf(&b, "-- \n%s\n\n", PATCH_SIGNATURE)
+	return b.String()
+}
+
+// messageBody is a commit message without its subject line.
+func messageBody(msg string) string {
+	parts := strings.SplitN(msg, "\n", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+/* ---- Parsing unified diffs ----*/
+
+type patchHunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	lines              []string // each starts with ' ', '-' or '+'
+}
+
+// patchFile is the part of a patch that changes one file.
+type patchFile struct {
+	oldPath, newPath string
+	isNew, isDelete  bool
+	isRename, isCopy bool
+	binary           bool   // a "Binary files ... differ" entry without content
+	oldBlob          string // abbreviated preimage ID from the "index" line
+	hunks            []patchHunk
+}
+
+// path is the name the change is reported under.
+func (f *patchFile) path() string {
+	if f.isDelete {
+		return f.oldPath
+	}
+	return f.newPath
+}
+
+// parsePatch reads every file change from a unified diff, with or without
+// git's extended headers. Text outside of diffs is ignored.
+func parsePatch(text string) ([]*patchFile, error) {
+	lines := strings.SplitAfter(text, "\n")
+	var files []*patchFile
+	var cur *patchFile
+	stripPrefix := func(p string) string {
+		p = strings.TrimRight(p, "\r\n")
+		if i := strings.IndexByte(p, '\t'); i >= 0 {
+			p = p[:i]
+		}
+		if p == "/dev/null" {
+			return ""
+		}
+		if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+			return p[2:]
+		}
+		return p
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			cur = &patchFile{}
+			files = append(files, cur)
+			names := strings.TrimPrefix(line, "diff --git ")
+			if j := strings.Index(names, " b/"); j >= 0 {
+				cur.oldPath, cur.newPath = stripPrefix(names[:j]), stripPrefix(names[j+1:])
+			}
+		case cur != nil && (strings.HasPrefix(line, "new file")):
+			cur.isNew = true
+		case cur != nil && strings.HasPrefix(line, "deleted file"):
+			cur.isDelete = true
+		case cur != nil && strings.HasPrefix(line, "rename from "):
+			cur.isRename, cur.oldPath = true, strings.TrimPrefix(line, "rename from ")
+		case cur != nil && strings.HasPrefix(line, "rename to "):
+			cur.isRename, cur.newPath = true, strings.TrimPrefix(line, "rename to ")
+		case cur != nil && strings.HasPrefix(line, "copy from "):
+			cur.isCopy, cur.oldPath = true, strings.TrimPrefix(line, "copy from ")
+		case cur != nil && strings.HasPrefix(line, "copy to "):
+			cur.isCopy, cur.newPath = true, strings.TrimPrefix(line, "copy to ")
+		case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ"):
+			// Plain diffs name the files only here.
+			if cur == nil || len(cur.hunks) > 0 || cur.binary {
+				cur = &patchFile{}
+				files = append(files, cur)
+				names := strings.TrimSuffix(strings.TrimPrefix(line, "Binary files "), " differ")
+				if j := strings.Index(names, " and "); j >= 0 {
+					old, new := stripPrefix(names[:j]), stripPrefix(names[j+5:])
+					cur.oldPath, cur.newPath = old, new
+					cur.isNew, cur.isDelete = old == "", new == ""
+				}
+			}
+			cur.binary = true
+		case cur != nil && line == "GIT binary patch":
+			cur.binary = true
+		case cur != nil && strings.HasPrefix(line, "index "):
+			if ids := strings.SplitN(strings.Fields(line)[1], "..", 2); len(ids) == 2 {
+				cur.oldBlob = ids[0]
+			}
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			// A plain unified diff has no "diff --git" line.
+			if cur == nil || len(cur.hunks) > 0 {
+				cur = &patchFile{}
+				files = append(files, cur)
+			}
+			old, new := stripPrefix(line[4:]), stripPrefix(lines[i+1][4:])
+			cur.isNew, cur.isDelete = cur.isNew || old == "", cur.isDelete || new == ""
+			if old != "" {
+				cur.oldPath = old
+			}
+			if new != "" {
+				cur.newPath = new
+			}
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("hunk without a file header at line %d", i+1)
+			}
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			cur.hunks = append(cur.hunks, h)
+			i = next - 1
+		}
+	}
+
+	for _, f := range files {
+		if f.isNew {
+			f.oldPath = ""
+		}
+		if f.isDelete {
+			f.newPath = ""
+		}
+		if f.oldPath == "" && f.newPath == "" {
+			return nil, fmt.Errorf("patch has a file without a name")
+		}
+		if !f.isNew && f.oldPath == "" {
+			f.oldPath = f.newPath
+		}
+		if !f.isDelete && f.newPath == "" {
+			f.newPath = f.oldPath
+		}
+	}
+	return files, nil
+}
+
+// parseHunk reads the hunk whose header is lines[at] and returns it with
+// the index of the first line after it.
+func parseHunk(lines []string, at int) (patchHunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[at])
+	if m == nil {
+		return patchHunk{}, 0, fmt.Errorf("malformed hunk header at line %d", at+1)
+	}
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	h := patchHunk{}
+	h.oldStart, _ = strconv.Atoi(m[1])
+	h.newStart, _ = strconv.Atoi(m[3])
+	h.oldCount, h.newCount = count(m[2]), count(m[4])
+
+	oldLeft, newLeft := h.oldCount, h.newCount
+	i := at + 1
+	for ; i < len(lines) && (oldLeft > 0 || newLeft > 0); i++ {
+		line := lines[i]
+		if line == "" || line == "\n" {
+			line = " \n" // some mailers drop the space of empty context lines
+		}
+		switch line[0] {
+		case ' ':
+			oldLeft--
+			newLeft--
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		case '\\':
+			h.markNoNewline()
+			continue
+		default:
+			return h, 0, fmt.Errorf("corrupt patch at line %d", i+1)
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		h.lines = append(h.lines, line)
+	}
+	if oldLeft != 0 || newLeft != 0 {
+		return h, 0, fmt.Errorf("truncated hunk at line %d", at+1)
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+		h.markNoNewline()
+		i++
+	}
+	return h, i, nil
+}
+
+// markNoNewline records "\ No newline at end of file" for the last line.
+func (h *patchHunk) markNoNewline() {
+	if n := len(h.lines); n > 0 {
+		h.lines[n-1] = strings.TrimSuffix(h.lines[n-1], "\n")
+	}
+}
+
+/* ---- Applying ----*/
+
+// applyHunks applies hunks to content. Each hunk must match exactly, at
+// its stated line or, if the file has shifted, at the nearest place.
+func applyHunks(content string, hunks []patchHunk) (string, error) {
+	lines := splitLines(content)
+	var out []string
+	pos := 0
+	for n, h := range hunks {
+		var pre, post []string
+		for _, l := range h.lines {
+			if l[0] != '+' {
+				pre = append(pre, l[1:])
+			}
+			if l[0] != '-' {
+				post = append(post, l[1:])
+			}
+		}
+		want := h.oldStart - 1
+		if h.oldCount == 0 {
+			want = h.oldStart
+		}
+		at := -1
+		for offset := 0; at < 0 && (want-offset >= pos || want+offset+len(pre) <= len(lines)); offset++ {
+			for _, cand := range []int{want - offset, want + offset} {
+				if cand >= pos && cand+len(pre) <= len(lines) && sameLines(lines[cand:cand+len(pre)], pre) {
+					at = cand
+					break
+				}
+			}
+		}
+		if at < 0 {
+			return "", fmt.Errorf("hunk #%d does not apply", n+1)
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, post...)
+		pos = at + len(pre)
+	}
+	out = append(out, lines[pos:]...)
+	return strings.Join(out, ""), nil
+}
+
+type applyOptions struct {
+	check    bool // only report whether the patch applies
+	threeWay bool // fall back to a three-way merge with the preimage
+	index    bool // apply to the working tree and the index
+	cached   bool // apply to the index only
+}
+
+// applyResult is the outcome for one path: new content, or removal.
+type applyResult struct {
+	path     string
+	content  string
+	remove   bool
+	conflict bool
+}
+
+// applyPatchFiles applies every file change of a patch, or nothing if any
+// of them fails. It returns the paths left with conflict markers by a
+// three-way merge.
+func applyPatchFiles(files []*patchFile, opts applyOptions) ([]string, error) {
+	index := indexFiles(getLastCommitFiles())
+	current := func(path string) (string, bool) {
+		if opts.cached {
+			content, ok := index[path]
+			return content, ok
+		}
+		return readWorkingFile(path)
+	}
+
+	var results []applyResult
+	var failures []string
+	for _, f := range files {
+		if f.binary {
+			failures = append(failures, f.path()+": cannot apply binary patch")
+			continue
+		}
+		if f.isNew {
+			if _, exists := current(f.newPath); exists {
+				failures = append(failures, f.newPath+": already exists")
+				continue
+			}
+		}
+		old := ""
+		if !f.isNew {
+			content, exists := current(f.oldPath)
+			if !exists {
+				failures = append(failures, f.oldPath+": does not exist")
+				continue
+			}
+			old = content
+		}
+		if opts.index && !f.isNew {
+			if staged, ok := index[f.oldPath]; !ok || staged != old {
+				failures = append(failures, f.oldPath+": does not match the index")
+				continue
+			}
+		}
+
+		new, err := applyHunks(old, f.hunks)
+		conflict := false
+		if err != nil && opts.threeWay {
+			base, found := findBlob(f.oldBlob, f.oldPath)
+			if !found {
+				failures = append(failures, fmt.Sprintf("%s: %v, and the original version %s is not in this repository", f.path(), err, f.oldBlob))
+				continue
+			}
+			theirs, baseErr := applyHunks(base, f.hunks)
+			if baseErr != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", f.path(), baseErr))
+				continue
+			}
+			new, conflict = merge3(base, old, theirs, "ours", "theirs")
+			err = nil
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", f.path(), err))
+			continue
+		}
+		if f.isDelete {
+			if new != "" {
+				failures = append(failures, f.oldPath+": deleted file still has content")
+				continue
+			}
+			results = append(results, applyResult{path: f.oldPath, remove: true})
+			continue
+		}
+		if f.isRename {
+			results = append(results, applyResult{path: f.oldPath, remove: true})
+		}
+		results = append(results, applyResult{path: f.newPath, content: new, conflict: conflict})
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("patch does not apply:\n  %s", strings.Join(failures, "\n  "))
+	}
+	if opts.check {
+		return nil, nil
+	}
+
+	var conflicts []string
+	for _, r := range results {
+		if !opts.cached {
+			if r.remove {
+				os.Remove(r.path)
+			} else if err := writeWorkingFile(r.path, r.content); err != nil {
+				return conflicts, err
+			}
+		}
+		if r.conflict {
+			conflicts = append(conflicts, r.path)
+			fmt.Printf("Applied patch to '%s' with conflicts.\n", r.path)
+			continue
+		}
+		if opts.index || opts.cached {
+			if r.remove {
+				stageRemoval(r.path)
+			} else {
+				staged := loadStaging()
+				staged[r.path] = r.content
+				saveStaging(staged)
+			}
+		}
+		fmt.Printf("Applied patch to '%s' cleanly.\n", r.path)
+	}
+	return conflicts, nil
+}
+
+// findBlob looks for the file version whose blob ID starts with prefix,
+// trying path first, in the index and then in every commit.
+func findBlob(prefix, path string) (string, bool) {
+	if len(prefix) < 4 || strings.Trim(prefix, "0") == "" {
+		return "", false
+	}
+	match := func(files map[string]string) (string, bool) {
+		if content, ok := files[path]; ok && strings.HasPrefix(blobID(content), prefix) {
+			return content, true
+		}
+		for _, content := range files {
+			if strings.HasPrefix(blobID(content), prefix) {
+				return content, true
+			}
+		}
+		return "", false
+	}
+	if content, ok := match(indexFiles(getLastCommitFiles())); ok {
+		return content, true
+	}
+	for _, id := range allCommitIDsAt(GUD_DIR) {
+		if content, ok := match(commitFiles(id)); ok {
+			return content, true
+		}
+	}
+	return "", false
+}
+
+// readPatchInput reads the named files, or stdin when there are none.
+func readPatchInput(names []string) (string, error) {
+	if len(names) == 0 || (len(names) == 1 && names[0] == "-") {
+		data, err := io.ReadAll(bufio.NewReader(os.Stdin))
+		return string(data), err
+	}
+	var b strings.Builder
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		b.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), nil
+}
+
+func applyUsage() {
+	fmt.Println("Usage: gud apply [--check] [-3|--3way] [--index | --cached] [<patch>...]")
+}
+
+// applyCommand applies patches to the working tree (and with --index also
+// to the index, or with --cached only to the index). Nothing is changed if
+// any part fails; --3way merges a failing change with the version it was
+// made against, when that version is in the repository.
+func applyCommand(args []string) {
+	var opts applyOptions
+	var names []string
+	for _, a := range args {
+		switch a {
+		case "--check":
+			opts.check = true
+		case "-3", "--3way":
+			opts.threeWay, opts.index = true, true
+		case "--index":
+			opts.index = true
+		case "--cached":
+			opts.cached = true
+		default:
+			if strings.HasPrefix(a, "-") && a != "-" {
+				fmt.Println("Error: unknown option:", a)
+				applyUsage()
+				return
+			}
+			names = append(names, a)
+		}
+	}
+	text, err := readPatchInput(names)
+	if err != nil {
+		fmt.Println("Error reading patch:", err)
+		return
+	}
+	files, err := parsePatch(text)
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("no changes found in patch")
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	conflicts, err := applyPatchFiles(files, opts)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	for _, p := range conflicts {
+		fmt.Println("U", p)
+	}
+}
+
+/* ---- am ----*/
+
+// amState is a patch series being applied. The first patch is the one
+// being worked on; Head is where the branch was before, for --abort.
+type amState struct {
+	Head     string   `json:"head"`
+	Patches  []string `json:"patches"`
+	Applied  int      `json:"applied"`
+	ThreeWay bool     `json:"three_way,omitempty"`
+}
+
+func loadAm() (*amState, bool) {
+	data, err := os.ReadFile(AM_FILE)
+	if err != nil {
+		return nil, false
+	}
+	var s amState
+	if json.Unmarshal(data, &s) != nil {
+		return nil, false
+	}
+	return &s, true
+}
+
+func saveAm(s *amState) {
+	data, _ := json.MarshalIndent(s, "", "  ")
+	os.WriteFile(AM_FILE, data, 0644)
+}
+
+// mailPatch is one message of a series.
+type mailPatch struct {
+	author  string
+	date    string // RFC 3339, "" if the mail has no usable Date
+	message string
+	diff    string
+}
+
+// splitMbox splits an mbox into messages at "From <id> " lines. Input
+// without such lines is taken as a single message.
+func splitMbox(text string) []string {
+	var messages []string
+	var cur strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if mboxFromLine.MatchString(line) && cur.Len() > 0 {
+			messages = append(messages, cur.String())
+			cur.Reset()
+		}
+		cur.WriteString(line)
+	}
+	if strings.TrimSpace(cur.String()) != "" {
+		messages = append(messages, cur.String())
+	}
+	return messages
+}
+
+// parseMail reads the author, date, message and diff of a patch email. The
+// message is the Subject (without its "[PATCH ...]" prefix) followed by the
+// body up to the "---" line or the diff.
+func parseMail(text string) mailPatch {
+	var m mailPatch
+	lines := strings.Split(text, "\n")
+	i := 0
+	if i < len(lines) && strings.HasPrefix(lines[i], "From ") && mboxFromLine.MatchString(lines[i]+"\n") {
+		i++
+	}
+	subject := ""
+	header := ""
+	for ; i < len(lines) && lines[i] != ""; i++ {
+		line := lines[i]
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && header == "subject" {
+			subject += " " + strings.TrimSpace(line)
+			continue
+		}
+		k := strings.Index(line, ":")
+		if k < 0 {
+			break // not a header block
+		}
+		header = strings.ToLower(line[:k])
+		value := strings.TrimSpace(line[k+1:])
+		switch header {
+		case "from":
+			m.author = value
+		case "date":
+			if t, err := mail.ParseDate(value); err == nil {
+				m.date = t.Format(time.RFC3339)
+			}
+		case "subject":
+			subject = value
+		}
+	}
+	if strings.HasPrefix(subject, "[") {
+		if k := strings.Index(subject, "]"); k >= 0 {
+			subject = strings.TrimSpace(subject[k+1:])
+		}
+	}
+
+	var body []string
+	for ; i < len(lines); i++ {
+		if lines[i] == "---" || strings.HasPrefix(lines[i], "diff --git ") || strings.HasPrefix(lines[i], "--- ") {
+			break
+		}
+		body = append(body, lines[i])
+	}
+	m.diff = strings.Join(lines[i:], "\n")
+	m.message = strings.TrimSpace(subject + "\n\n" + strings.TrimSpace(strings.Join(body, "\n")))
+	return m
+}
+
+func amUsage() {
+	fmt.Println("Usage: gud am [-3|--3way] [<mbox>...] | gud am --continue | gud am --skip | gud am --abort")
+}
+
+// amCommand applies a series of patch emails as commits on the current
+// branch. If a patch does not apply, am stops; fix things up and stage the
+// result, then run --continue, or --skip the patch, or --abort the series.
+func amCommand(args []string) {
+	threeWay := false
+	var names []string
+	for _, a := range args {
+		switch a {
+		case "--continue", "--skip", "--abort":
+			if len(args) != 1 {
+				amUsage()
+				return
+			}
+			amResume(a[2:])
+			return
+		case "-3", "--3way":
+			threeWay = true
+		default:
+			if strings.HasPrefix(a, "-") && a != "-" {
+				fmt.Println("Error: unknown option:", a)
+				amUsage()
+				return
+			}
+			names = append(names, a)
+		}
+	}
+	if _, ok := loadAm(); ok {
+		fmt.Println("A patch series is already being applied; use --continue, --skip or --abort.")
+		return
+	}
+	if mergeInProgress() {
+		fmt.Println("A merge is in progress; commit the result or run 'gud merge --abort'.")
+		return
+	}
+	if len(loadStaging()) > 0 || len(loadStagedRemovals()) > 0 {
+		fmt.Println("You have staged changes; commit or unstage them before running am.")
+		return
+	}
+	text, err := readPatchInput(names)
+	if err != nil {
+		fmt.Println("Error reading patches:", err)
+		return
+	}
+	state := &amState{Head: currentBranchHead(), Patches: splitMbox(text), ThreeWay: threeWay}
+	if len(state.Patches) == 0 {
+		fmt.Println("No patches found.")
+		return
+	}
+	runAm(state)
+}
+
+// runAm applies and commits patches until the series is done or one of
+// them needs the user.
+func runAm(state *amState) {
+	for len(state.Patches) > 0 {
+		m := parseMail(state.Patches[0])
+		fmt.Println("Applying:", subjectLine(m.message))
+		files, err := parsePatch(m.diff)
+		if err == nil && len(files) == 0 {
+			err = fmt.Errorf("no changes found in patch")
+		}
+		var conflicts []string
+		if err == nil {
+			conflicts, err = applyPatchFiles(files, applyOptions{index: true, threeWay: state.ThreeWay})
+		}
+		if err != nil || len(conflicts) > 0 {
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+			if len(conflicts) > 0 {
+				data, _ := json.MarshalIndent(conflicts, "", "  ")
+				os.WriteFile(MERGE_CONFLICTS_FILE, data, 0644)
+			}
+			saveAm(state)
+			fmt.Printf("Patch failed at %04d %s\n", state.Applied+1, subjectLine(m.message))
+			fmt.Println("Resolve the problem, stage the result with 'gud add' and run 'gud am --continue'.")
+			fmt.Println("Use 'gud am --skip' to drop this patch or 'gud am --abort' to restore the original branch.")
+			return
+		}
+		if !amCommit(state, m) {
+			return
+		}
+	}
+	os.Remove(AM_FILE)
+}
+
+// amCommit commits the staged result of the current patch and moves on.
+func amCommit(state *amState, m mailPatch) bool {
+	before := currentBranchHead()
+	createCommit(m.message, m.author, m.date, configBool("commit.gpgSign", false), true)
+	if currentBranchHead() == before {
+		saveAm(state)
+		fmt.Println("The patch was not committed; fix the problem and run 'gud am --continue'.")
+		return false
+	}
+	state.Patches = state.Patches[1:]
+	state.Applied++
+	saveAm(state)
+	return true
+}
+
+func amResume(action string) {
+	state, ok := loadAm()
+	if !ok {
+		fmt.Println("No patch series in progress.")
+		return
+	}
+	switch action {
+	case "abort":
+		os.Remove(AM_FILE)
+		resetBranch("hard", "HEAD before am", state.Head)
+	case "skip":
+		resetBranch("hard", "HEAD", currentBranchHead())
+		state.Patches = state.Patches[1:]
+		state.Applied++
+		runAm(state)
+	case "continue":
+		if len(loadStaging()) == 0 && len(loadStagedRemovals()) == 0 {
+			fmt.Println("No changes staged; did you forget to use 'gud add'? Use 'gud am --skip' to drop the patch.")
+			return
+		}
+		if amCommit(state, parseMail(state.Patches[0])) {
+			runAm(state)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// formatPatchFile writes the patch of one commit as format-patch would.
+func formatPatchFile(t *testing.T, id string) string {
+	t.Helper()
+	c, err := readCommit(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "patch-" + shortID(id) + ".mbox"
+	if err := os.WriteFile(name, []byte(formatPatch(c, 1, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestAmRoundTrip(t *testing.T) {
+	newTestRepo(t)
+	base := commitFilesForTest(t, "base", map[string]string{"a.txt": "one\n"})
+	text := commitFilesForTest(t, "edit text", map[string]string{"a.txt": "one\ntwo\n"})
+	textPatch := formatPatchFile(t, text)
+
+	resetBranch("hard", "HEAD~1", base)
+	amCommand([]string{textPatch})
+	if _, ok := loadAm(); ok {
+		t.Fatal("am stopped on a text patch")
+	}
+	c, err := readCommit(currentBranchHead())
+	if err != nil || c.Files["a.txt"] != "one\ntwo\n" || c.Message != "edit text" {
+		t.Fatalf("text patch did not round-trip: %+v, %v", c, err)
+	}
+	if got := readTestFile(t, "a.txt"); got != "one\ntwo\n" {
+		t.Errorf("a.txt = %q after am", got)
+	}
+
+	// am must refuse a binary change it has no content for, and apply
+	// nothing of that patch.
+	failsOn := func(name string, start, patch, wantBin string) {
+		t.Helper()
+		resetBranch("hard", "HEAD", start)
+		amCommand([]string{patch})
+		if _, ok := loadAm(); !ok {
+			t.Fatalf("%s: am applied a binary patch it has no content for", name)
+		}
+		if got := currentBranchHead(); got != start {
+			t.Errorf("%s: am committed %s", name, shortID(got))
+		}
+		if got := readTestFile(t, "a.txt"); got != "one\ntwo\n" {
+			t.Errorf("%s: a.txt = %q; part of a failed patch was applied", name, got)
+		}
+		data, err := os.ReadFile("bin.dat")
+		if wantBin == "" && err == nil {
+			t.Errorf("%s: am created bin.dat with %q", name, data)
+		} else if wantBin != "" && string(data) != wantBin {
+			t.Errorf("%s: bin.dat = %q, want %q", name, data, wantBin)
+		}
+		amResume("abort")
+	}
+
+	start := currentBranchHead()
+	added := commitFilesForTest(t, "add binary", map[string]string{"bin.dat": "\x00\x01data", "a.txt": "one\ntwo\nthree\n"})
+	failsOn("added binary file", start, formatPatchFile(t, added), "")
+
+	withBin := commitFilesForTest(t, "add binary", map[string]string{"bin.dat": "\x00\x01data"})
+	modified := commitFilesForTest(t, "edit binary", map[string]string{"bin.dat": "\x00\x01changed", "a.txt": "one\ntwo\nthree\n"})
+	failsOn("modified binary file", withBin, formatPatchFile(t, modified), "\x00\x01data")
+
+	files, err := parsePatch("Binary files a/x.bin and b/x.bin differ\n")
+	if err != nil || len(files) != 1 || !files[0].binary || files[0].path() != "x.bin" {
+		t.Errorf("plain binary diff parsed as %+v, %v", files, err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   Reflog: the history of every branch and of HEAD
+-------------------------------------------*/
+
+// Each ref has a log under .gud/reflogs/ (reflogs/HEAD,
+// reflogs/refs/heads/<branch>) with one JSON entry per line, oldest first.
+// Entries outlive the branch they belong to, so a deleted branch or an
+// amended commit can be recovered with "<ref>@{N}".
+
+const (
+	REFLOG_DIR            = ".gud/reflogs"
+	DEFAULT_REFLOG_EXPIRE = "90.days.ago"
+)
+
+type reflogEntry struct {
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	Identity  string `json:"identity"`
+	Timestamp string `json:"timestamp"`
+	Reason    string `json:"reason"`
+}
+
+func reflogPathAt(gudDir, ref string) string {
+	return filepath.Join(gudDir, "reflogs", filepath.FromSlash(ref))
+}
+
+// appendReflogAt records that ref moved from old to new.
+func appendReflogAt(gudDir, ref, old, new, reason string) {
+	e := reflogEntry{
+		Old:       old,
+		New:       new,
+		Identity:  userIdentity(),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Reason:    reason,
+	}
+	data, err := json.Marshal(&e)
+	if err != nil {
+		return
+	}
+	path := reflogPathAt(gudDir, ref)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(append(data, '\n'))
+}
+
+// logBranchUpdateAt records a branch update in the branch's reflog, and in
+// HEAD's if the branch is checked out. Nothing is logged if the branch did
+// not move.
+func logBranchUpdateAt(gudDir, branch, old, new, reason string) {
+	if old == new {
+		return
+	}
+	appendReflogAt(gudDir, "refs/heads/"+branch, old, new, reason)
+	if data, err := os.ReadFile(filepath.Join(gudDir, "HEAD")); err == nil && strings.TrimSpace(string(data)) == branch {
+		appendReflogAt(gudDir, "HEAD", old, new, reason)
+	}
+}
+
+// readReflogAt returns a ref's entries, newest first.
+func readReflogAt(gudDir, ref string) []reflogEntry {
+	f, err := os.Open(reflogPathAt(gudDir, ref))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e reflogEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append([]reflogEntry{e}, entries...)
+		}
+	}
+	return entries
+}
+
+func writeReflogAt(gudDir, ref string, entries []reflogEntry) error {
+	path := reflogPathAt(gudDir, ref)
+	var b strings.Builder
+	for i := len(entries) - 1; i >= 0; i-- {
+		data, err := json.Marshal(&entries[i])
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// reflogRefs lists every ref that has a reflog.
+func reflogRefs() []string {
+	return reflogRefsAt(GUD_DIR)
+}
+
+func reflogRefsAt(gudDir string) []string {
+	var refs []string
+	dir := filepath.Join(gudDir, "reflogs")
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		refs = append(refs, filepath.ToSlash(rel))
+		return nil
+	})
+	return refs
+}
+
+// reflogRef turns "HEAD", a branch name or a full ref into the name of its
+// reflog. An empty name means the current branch.
+func reflogRef(name string) string {
+	switch {
+	case name == "":
+		return "refs/heads/" + currentBranch()
+	case name == "HEAD" || name == "@" || strings.HasPrefix(name, "refs/"):
+		if name == "@" {
+			return "HEAD"
+		}
+		return name
+	}
+	return "refs/heads/" + name
+}
+
+// reflogRevision resolves "<ref>@{N}", the value ref had N updates ago.
+// "@{N}" alone refers to the current branch.
+func reflogRevision(name string) (string, bool, error) {
+	i := strings.Index(name, "@{")
+	if i < 0 || !strings.HasSuffix(name, "}") {
+		return "", false, nil
+	}
+	n, err := strconv.Atoi(name[i+2 : len(name)-1])
+	if err != nil || n < 0 {
+		return "", true, fmt.Errorf("invalid reflog index in %s", name)
+	}
+	ref := reflogRef(name[:i])
+	entries := readReflogAt(GUD_DIR, ref)
+	if n >= len(entries) {
+		if n == len(entries) && n > 0 && entries[n-1].Old != "" {
+			return entries[n-1].Old, true, nil
+		}
+		return "", true, fmt.Errorf("log for %s only has %d entries", name[:i], len(entries))
+	}
+	if entries[n].New == "" {
+		return "", true, fmt.Errorf("%s was deleted at %s", name, entries[n].Timestamp)
+	}
+	return entries[n].New, true, nil
+}
+
+func handleReflogCommand(args []string) {
+	if len(args) > 0 && args[0] == "expire" {
+		reflogExpireCommand(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "show" {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		fmt.Println("Usage: gud reflog [show] [<ref>] | gud reflog expire [--expire=<time>] [--dry-run]")
+		return
+	}
+	name := optionalArg(args)
+	if name == "" {
+		name = "HEAD"
+	}
+	ref := reflogRef(name)
+	entries := readReflogAt(GUD_DIR, ref)
+	if len(entries) == 0 {
+		fmt.Println("No reflog for", name)
+		return
+	}
+	for n, e := range entries {
+		id := e.New
+		if id == "" {
+			id = ZERO_ID
+		}
+		fmt.Printf("%s %s@{%d}: %s\n", shortID(id), name, n, e.Reason)
+	}
+}
+
+func reflogExpiry(arg string) (time.Time, error) {
+	if arg == "" {
+		arg = configString("gc.reflogExpire", DEFAULT_REFLOG_EXPIRE)
+	}
+	return parseExpiry(arg)
+}
+
+// expireReflogsAt drops entries older than cutoff from every reflog. It
+// returns how many were (or, with dryRun, would be) removed.
+func expireReflogsAt(gudDir string, cutoff time.Time, dryRun bool) int {
+	removed := 0
+	for _, ref := range reflogRefsAt(gudDir) {
+		entries := readReflogAt(gudDir, ref)
+		var kept []reflogEntry
+		for _, e := range entries {
+			t, err := time.Parse(time.RFC3339, e.Timestamp)
+			if err == nil && expired(t, cutoff) {
+				removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if dryRun || len(kept) == len(entries) {
+			continue
+		}
+		if err := writeReflogAt(gudDir, ref, kept); err != nil {
+			fmt.Println("Error writing reflog:", err)
+		}
+	}
+	return removed
+}
+
+func reflogExpireCommand(args []string) {
+	expire := ""
+	dryRun := false
+	for _, a := range args {
+		switch {
+		case a == "--dry-run" || a == "-n":
+			dryRun = true
+		case strings.HasPrefix(a, "--expire="):
+			expire = strings.TrimPrefix(a, "--expire=")
+		default:
+			fmt.Println("Usage: gud reflog expire [--expire=<time>] [--dry-run]")
+			return
+		}
+	}
+	cutoff, err := reflogExpiry(expire)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	removed := expireReflogsAt(GUD_DIR, cutoff, dryRun)
+	if dryRun {
+		fmt.Printf("Would remove %d reflog entries.\n", removed)
+		return
+	}
+	fmt.Printf("Removed %d reflog entries.\n", removed)
+}
+
+// reflogIDs lists every commit a reflog entry still refers to.
+func reflogIDs() []string {
+	return reflogIDsAt(GUD_DIR)
+}
+
+func reflogIDsAt(gudDir string) []string {
+	var ids []string
+	for _, ref := range reflogRefsAt(gudDir) {
+		for _, e := range readReflogAt(gudDir, ref) {
+			for _, id := range []string{e.Old, e.New} {
+				if id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
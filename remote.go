@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ----------------------------------------
+   Named remotes (remote.<name>.url in config)
+-------------------------------------------*/
+
+const DEFAULT_REMOTE = "origin"
+
+func handleRemoteCommand(args []string) {
+	if len(args) == 0 {
+		listRemotes(false)
+		return
+	}
+	switch args[0] {
+	case "list", "-v", "--verbose":
+		verbose := args[0] != "list"
+		for _, a := range args[1:] {
+			if a == "-v" || a == "--verbose" {
+				verbose = true
+			}
+		}
+		listRemotes(verbose)
+	case "add":
+		if len(args) != 3 {
+			fmt.Println("Usage: gud remote add <name> <url>")
+			return
+		}
+		addRemote(args[1], args[2])
+	case "remove", "rm":
+		if len(args) != 2 {
+			fmt.Println("Usage: gud remote remove <name>")
+			return
+		}
+		removeRemote(args[1])
+	case "rename":
+		if len(args) != 3 {
+			fmt.Println("Usage: gud remote rename <old> <new>")
+			return
+		}
+		renameRemote(args[1], args[2])
+	case "set-url":
+		if len(args) != 3 {
+			fmt.Println("Usage: gud remote set-url <name> <url>")
+			return
+		}
+		setRemoteURLFor(args[1], args[2])
+	case "get-url":
+		if len(args) != 2 {
+			fmt.Println("Usage: gud remote get-url <name>")
+			return
+		}
+		url, ok := remoteURL(args[1])
+		if !ok {
+			fmt.Println("No such remote:", args[1])
+			return
+		}
+		fmt.Println(url)
+	default:
+		fmt.Println("Unknown remote command:", args[0])
+	}
+}
+
+// remoteNames lists every remote that has a URL configured in any scope.
+func remoteNames() []string {
+	seen := make(map[string]bool)
+	for _, s := range configScopes() {
+		for k := range loadConfigFile(s.Path) {
+			if strings.HasPrefix(k, "remote.") && strings.HasSuffix(k, ".url") {
+				name := strings.TrimSuffix(strings.TrimPrefix(k, "remote."), ".url")
+				if name != "" {
+					seen[name] = true
+				}
+			}
+		}
+	}
+	if !seen[DEFAULT_REMOTE] {
+		if _, err := os.Stat(REMOTE_URL_FILE); err == nil {
+			seen[DEFAULT_REMOTE] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func remoteURL(name string) (string, bool) {
+	if url, ok := configValue("remote." + name + ".url"); ok {
+		return url, true
+	}
+	// Older repositories kept a single remote in .gud/remote_url.
+	if name == DEFAULT_REMOTE {
+		if data, err := os.ReadFile(REMOTE_URL_FILE); err == nil {
+			return strings.TrimSpace(string(data)), true
+		}
+	}
+	return "", false
+}
+
+func listRemotes(verbose bool) {
+	for _, name := range remoteNames() {
+		if verbose {
+			url, _ := remoteURL(name)
+			fmt.Printf("%s\t%s\n", name, url)
+		} else {
+			fmt.Println(name)
+		}
+	}
+}
+
+// validRemoteName rejects names that cannot be a path component or that
+// contain a dot, which would blur the "remote.<name>.<key>" config keys.
+func validRemoteName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t/\\:.")
+}
+
+func addRemote(name, url string) {
+	if !validRemoteName(name) {
+		fmt.Println("Invalid remote name:", name)
+		return
+	}
+	if _, ok := remoteURL(name); ok {
+		fmt.Println("Remote already exists:", name)
+		return
+	}
+	scope, _ := configScopeByName("local")
+	if err := setConfigValue(scope, "remote."+name+".url", url); err != nil {
+		fmt.Println("Error saving remote:", err)
+		return
+	}
+	fmt.Printf("Added remote '%s' -> %s\n", name, url)
+}
+
+func removeRemote(name string) {
+	if _, ok := remoteURL(name); !ok {
+		fmt.Println("No such remote:", name)
+		return
+	}
+	scope, _ := configScopeByName("local")
+	if err := unsetConfigSection(scope, "remote", name); err != nil {
+		fmt.Println("Error saving config:", err)
+		return
+	}
+	if name == DEFAULT_REMOTE {
+		os.Remove(REMOTE_URL_FILE)
+	}
+	os.Remove(filepath.Join(REMOTE_REFS_DIR, name+".json"))
+	for k, v := range loadConfigFile(scope.Path) {
+		if strings.HasPrefix(k, "branch.") && strings.HasSuffix(k, ".remote") && v == name {
+			branch := strings.TrimSuffix(strings.TrimPrefix(k, "branch."), ".remote")
+			unsetConfigValue(scope, k)
+			unsetConfigValue(scope, "branch."+branch+".merge")
+		}
+	}
+	fmt.Println("Removed remote:", name)
+}
+
+func renameRemote(oldName, newName string) {
+	if _, ok := remoteURL(oldName); !ok {
+		fmt.Println("No such remote:", oldName)
+		return
+	}
+	if !validRemoteName(newName) {
+		fmt.Println("Invalid remote name:", newName)
+		return
+	}
+	if _, ok := remoteURL(newName); ok {
+		fmt.Println("Remote already exists:", newName)
+		return
+	}
+
+	scope, _ := configScopeByName("local")
+	old := loadConfigFile(scope.Path)
+	cfg := make(map[string]string, len(old))
+	newPrefix := "remote." + newName + "."
+	for k, v := range old {
+		if sub, _, ok := configSubsection(k, "remote"); ok && sub == oldName {
+			continue
+		}
+		if strings.HasPrefix(k, "branch.") && strings.HasSuffix(k, ".remote") && v == oldName {
+			v = newName
+		}
+		cfg[k] = v
+	}
+	for k, v := range old {
+		if sub, name, ok := configSubsection(k, "remote"); ok && sub == oldName {
+			cfg[newPrefix+name] = v
+		}
+	}
+	if _, ok := cfg[newPrefix+"url"]; !ok {
+		url, _ := remoteURL(oldName)
+		cfg[newPrefix+"url"] = url
+	}
+	if err := saveConfigFile(scope.Path, cfg); err != nil {
+		fmt.Println("Error saving config:", err)
+		return
+	}
+	if oldName == DEFAULT_REMOTE {
+		os.Remove(REMOTE_URL_FILE)
+	}
+	os.Rename(filepath.Join(REMOTE_REFS_DIR, oldName+".json"), filepath.Join(REMOTE_REFS_DIR, newName+".json"))
+	fmt.Printf("Renamed remote '%s' to '%s'\n", oldName, newName)
+}
+
+func setRemoteURLFor(name, url string) {
+	if _, ok := remoteURL(name); !ok {
+		fmt.Println("No such remote:", name)
+		return
+	}
+	scope, _ := configScopeByName("local")
+	if err := setConfigValue(scope, "remote."+name+".url", url); err != nil {
+		fmt.Println("Error saving remote:", err)
+		return
+	}
+	fmt.Printf("Remote '%s' now points to %s\n", name, url)
+}
+
+// resolveRemote turns a remote name (or a literal path/URL) into a URL.
+func resolveRemote(nameOrURL string) (string, error) {
+	if nameOrURL == "" {
+		nameOrURL = DEFAULT_REMOTE
+	}
+	if url, ok := remoteURL(nameOrURL); ok {
+		return url, nil
+	}
+	if strings.Contains(nameOrURL, "/") || strings.Contains(nameOrURL, "\\") || strings.Contains(nameOrURL, ":") || nameOrURL == "." || nameOrURL == ".." {
+		return nameOrURL, nil
+	}
+	return "", fmt.Errorf("no such remote: %s", nameOrURL)
+}
+
+// localRemoteGudDir maps a path or file:// URL to the directory holding the
+// remote's repository data: <path>/.gud for a working repository, or <path>
+// itself for a bare one. Anything else is not a repository; bare
+// repositories are made with "gud init --bare" or "gud clone --bare".
+func localRemoteGudDir(url string) (string, error) {
+	path := url
+	if strings.HasPrefix(path, "file://") {
+		path = strings.TrimPrefix(path, "file://")
+	} else if i := strings.Index(path, "://"); i > 0 {
+		return "", fmt.Errorf("unsupported remote URL: %s", url)
+	}
+	if info, err := os.Stat(filepath.Join(path, GUD_DIR)); err == nil && info.IsDir() {
+		return filepath.Join(path, GUD_DIR), nil
+	}
+	if isBareRepoAt(path) {
+		return path, nil
+	}
+	return "", fmt.Errorf("remote repository not found: %s", url)
+}
+
+// isBareRepoAt reports whether dir has the layout of a bare repository:
+// branches next to loose or packed objects.
+func isBareRepoAt(dir string) bool {
+	isDir := func(name string) bool {
+		info, err := os.Stat(filepath.Join(dir, name))
+		return err == nil && info.IsDir()
+	}
+	return isDir("branches") && (isDir("commits") || isDir("objects"))
+}
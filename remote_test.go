@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestRemoteSubsectionsAreMatchedExactly(t *testing.T) {
+	newTestRepo(t)
+	scope, _ := configScopeByName("local")
+	// Written directly: older versions accepted dotted remote names.
+	saveConfigFile(scope.Path, map[string]string{
+		"remote.a.url":       "/a",
+		"remote.a.b.url":     "/ab",
+		"remote.a.b.fetch":   "all",
+		"branch.main.remote": "a",
+	})
+
+	renameRemote("a", "c")
+	cfg := loadConfigFile(scope.Path)
+	want := map[string]string{
+		"remote.c.url":       "/a",
+		"remote.a.b.url":     "/ab",
+		"remote.a.b.fetch":   "all",
+		"branch.main.remote": "c",
+	}
+	if len(cfg) != len(want) {
+		t.Fatalf("config after rename = %v, want %v", cfg, want)
+	}
+	for k, v := range want {
+		if cfg[k] != v {
+			t.Errorf("%s = %q after rename, want %q", k, cfg[k], v)
+		}
+	}
+
+	removeRemote("c")
+	cfg = loadConfigFile(scope.Path)
+	if _, ok := cfg["remote.a.b.url"]; !ok || len(cfg) != 2 {
+		t.Errorf("config after removing c = %v, want only remote a.b", cfg)
+	}
+
+	for _, name := range []string{"a.b", ".hidden", "x/y", ""} {
+		if validRemoteName(name) {
+			t.Errorf("remote name %q accepted", name)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ----------------------------------------
+   Rename and copy detection
+-------------------------------------------*/
+
+// Snapshots are keyed by path, so a moved file looks like a deletion and an
+// addition. Detection pairs a new file with a deleted one (a rename) or, if
+// copies are wanted, with any file of the old snapshot (a copy) when enough
+// of their lines match. diff.renames ("true", "false" or "copies") and
+// diff.renameThreshold (a percentage, default 50) set the defaults; -M[<n>],
+// -C[<n>] and --no-renames override them per command.
+
+const DEFAULT_RENAME_THRESHOLD = 50
+
+// fileChange is one entry of a snapshot comparison. Old and New are the
+// same path except for renames and copies; additions have no Old and
+// deletions no New.
+type fileChange struct {
+	Status   byte // 'A', 'D', 'M', 'R' or 'C'
+	Old, New string
+	Score    int // similarity of a rename or copy, in percent
+}
+
+type renameDetection struct {
+	renames, copies bool
+	threshold       int
+}
+
+// defaultRenameDetection reads diff.renames and diff.renameThreshold.
+func defaultRenameDetection() renameDetection {
+	d := renameDetection{renames: true, threshold: DEFAULT_RENAME_THRESHOLD}
+	if v, ok := configValue("diff.renames"); ok {
+		if strings.EqualFold(strings.TrimSpace(v), "copies") || strings.EqualFold(strings.TrimSpace(v), "copy") {
+			d.copies = true
+		} else {
+			d.renames = configBool("diff.renames", true)
+		}
+	}
+	if v, ok := configValue("diff.renameThreshold"); ok {
+		if n, err := parseThreshold(v); err == nil {
+			d.threshold = n
+		}
+	}
+	return d
+}
+
+// parseThreshold reads a similarity percentage such as "60" or "60%".
+func parseThreshold(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("invalid similarity threshold: %s", s)
+	}
+	return n, nil
+}
+
+// renameFlags removes -M[<n>], --find-renames[=<n>], -C[<n>],
+// --find-copies[=<n>] and --no-renames from args and applies them to d.
+func renameFlags(args []string, d renameDetection) ([]string, renameDetection, error) {
+	var rest []string
+	for _, a := range args {
+		value := ""
+		switch {
+		case a == "--no-renames":
+			d.renames, d.copies = false, false
+			continue
+		case strings.HasPrefix(a, "-M"):
+			d.renames, value = true, a[2:]
+		case strings.HasPrefix(a, "--find-renames"):
+			d.renames, value = true, strings.TrimPrefix(strings.TrimPrefix(a, "--find-renames"), "=")
+		case strings.HasPrefix(a, "-C") && a != "-C":
+			d.renames, d.copies, value = true, true, a[2:]
+		case a == "-C":
+			d.renames, d.copies = true, true
+		case strings.HasPrefix(a, "--find-copies"):
+			d.renames, d.copies, value = true, true, strings.TrimPrefix(strings.TrimPrefix(a, "--find-copies"), "=")
+		default:
+			rest = append(rest, a)
+			continue
+		}
+		if value != "" {
+			n, err := parseThreshold(value)
+			if err != nil {
+				return nil, d, err
+			}
+			d.threshold = n
+		}
+	}
+	return rest, d, nil
+}
+
+// similarity scores how alike two texts are, from 0 to 100: the share of
+// their lines that a line diff keeps unchanged.
+func similarity(a, b string) int {
+	la, lb := splitLines(a), splitLines(b)
+	if len(la)+len(lb) == 0 {
+		return 100
+	}
+	common := 0
+	for _, op := range diffLines(la, lb) {
+		if op.Kind == ' ' {
+			common++
+		}
+	}
+	return 200 * common / (len(la) + len(lb))
+}
+
+// changes compares two snapshots and returns their differences in path
+// order, with renames and copies paired up. Identical content is matched
+// first, then the most similar pairs above the threshold.
+func (d renameDetection) changes(a, b map[string]string) []fileChange {
+	var added, deleted []string
+	var result []fileChange
+	for _, p := range touchedPaths(a, b) {
+		_, inA := a[p]
+		_, inB := b[p]
+		switch {
+		case inA && inB:
+			result = append(result, fileChange{Status: 'M', Old: p, New: p})
+		case inB:
+			added = append(added, p)
+		default:
+			deleted = append(deleted, p)
+		}
+	}
+
+	paired := make(map[string]bool) // added paths that found a source
+	used := make(map[string]bool)   // deleted paths that were renamed
+	if d.renames && len(added) > 0 {
+		// Sources: deleted files for renames, and with copies every file of
+		// a that is still in b.
+		sources := append([]string(nil), deleted...)
+		if d.copies {
+			for p := range a {
+				if _, ok := b[p]; ok {
+					sources = append(sources, p)
+				}
+			}
+			sort.Strings(sources)
+		}
+
+		type candidate struct {
+			src, dst string
+			score    int
+		}
+		var candidates []candidate
+		for _, dst := range added {
+			for _, src := range sources {
+				if a[src] == b[dst] {
+					candidates = append(candidates, candidate{src, dst, 100})
+				} else if score := similarity(a[src], b[dst]); score >= d.threshold {
+					candidates = append(candidates, candidate{src, dst, score})
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].score > candidates[j].score
+		})
+		for _, c := range candidates {
+			if paired[c.dst] {
+				continue
+			}
+			_, kept := b[c.src]
+			status := byte('C')
+			if !kept {
+				// A deleted file is renamed once; further matches are copies.
+				if used[c.src] {
+					if !d.copies {
+						continue
+					}
+				} else {
+					status = 'R'
+					used[c.src] = true
+				}
+			}
+			paired[c.dst] = true
+			result = append(result, fileChange{Status: status, Old: c.src, New: c.dst, Score: c.score})
+		}
+	}
+	for _, p := range added {
+		if !paired[p] {
+			result = append(result, fileChange{Status: 'A', New: p})
+		}
+	}
+	for _, p := range deleted {
+		if !used[p] {
+			result = append(result, fileChange{Status: 'D', Old: p})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return changePath(result[i]) < changePath(result[j])
+	})
+	return result
+}
+
+func changePath(ch fileChange) string {
+	if ch.Status == 'D' {
+		return ch.Old
+	}
+	return ch.New
+}
+
+// String formats a change as "R old -> new" or "M path".
+func (ch fileChange) String() string {
+	switch ch.Status {
+	case 'R', 'C':
+		return fmt.Sprintf("%c %s -> %s", ch.Status, ch.Old, ch.New)
+	case 'D':
+		return "D " + ch.Old
+	}
+	return string(ch.Status) + " " + ch.New
+}
+
+// renameSource finds the path a file had in a parent. If the parent has
+// no file at path, a file the child no longer has is taken as its old name
+// when its content is similar enough.
+func renameSource(parent, child map[string]string, path, content string) (string, bool) {
+	if _, ok := parent[path]; ok {
+		return path, true
+	}
+	d := defaultRenameDetection()
+	if !d.renames {
+		return "", false
+	}
+	best, bestScore := "", d.threshold-1
+	for p, old := range parent {
+		if _, stillThere := child[p]; stillThere {
+			continue
+		}
+		if score := similarity(old, content); score > bestScore || (score == bestScore && best != "" && p < best) {
+			best, bestScore = p, score
+		}
+	}
+	return best, best != ""
+}
+
+// alignRenames prepares a three-way merge across renames. When one side
+// renamed a file that the other side still has under its old name, the
+// other side's version (and the base's) is moved to the new name, so its
+// changes are merged into the renamed file.
+func alignRenames(base, ours, theirs map[string]string) (map[string]string, map[string]string, map[string]string) {
+	d := defaultRenameDetection()
+	if !d.renames {
+		return base, ours, theirs
+	}
+	d.copies = false
+	newBase, newOurs, newTheirs := copyFiles(base), copyFiles(ours), copyFiles(theirs)
+	move := func(renamed, other map[string]string, target map[string]string) {
+		for _, ch := range d.changes(base, renamed) {
+			if ch.Status != 'R' {
+				continue
+			}
+			content, stillOld := other[ch.Old]
+			if _, clash := other[ch.New]; !stillOld || clash {
+				continue
+			}
+			delete(target, ch.Old)
+			target[ch.New] = content
+			delete(newBase, ch.Old)
+			newBase[ch.New] = base[ch.Old]
+		}
+	}
+	move(ours, theirs, newTheirs)
+	move(theirs, ours, newOurs)
+	return newBase, newOurs, newTheirs
+}
+
+func copyFiles(files map[string]string) map[string]string {
+	c := make(map[string]string, len(files))
+	for k, v := range files {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+/* ----------------------------------------
+   gud reset: move the current branch, the index and the working tree
+-------------------------------------------*/
+
+// resetCommand handles "[--soft|--mixed|--hard] [<rev>]" and
+// "[<rev>] [--] <path>...".
+func resetCommand(args []string) {
+	mode := "mixed"
+	var positional, paths []string
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; a {
+		case "--soft", "--mixed", "--hard":
+			mode = a[2:]
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			positional = append(positional, a)
+		}
+	}
+
+	// Without "--", arguments that are not revisions are paths.
+	if len(paths) == 0 && len(positional) > 0 {
+		first := 0
+		if _, err := resolveRevision(positional[0]); err == nil {
+			first = 1
+		}
+		if len(positional) > first {
+			paths = positional[first:]
+			positional = positional[:first]
+		}
+	}
+	if len(positional) > 1 || (len(paths) > 0 && mode != "mixed") {
+		fmt.Println("Usage: gud reset [--soft|--mixed|--hard] [<revision>] | gud reset [<revision>] [--] <path>...")
+		return
+	}
+	rev := "HEAD"
+	if len(positional) == 1 {
+		rev = positional[0]
+	}
+	target, err := resolveRevision(rev)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(paths) > 0 {
+		resetPaths(target, paths)
+		return
+	}
+	resetBranch(mode, rev, target)
+}
+
+// resetBranch points the current branch at target. --soft keeps the index
+// and working tree, --mixed also resets the index and --hard also the
+// working tree, removing tracked files that target does not have.
+func resetBranch(mode, rev, target string) {
+	if mode == "soft" && mergeInProgress() {
+		fmt.Println("Cannot do a soft reset in the middle of a merge.")
+		return
+	}
+	head := currentBranchHead()
+	oldIndex := indexFiles(commitFiles(head))
+	to := commitFiles(target)
+
+	switch mode {
+	case "soft":
+		// The staging area is relative to HEAD: restate the old index
+		// against the new HEAD so that its content does not change.
+		staged := make(map[string]string)
+		var removed []string
+		for p, content := range oldIndex {
+			if old, ok := to[p]; !ok || old != content {
+				staged[p] = content
+			}
+		}
+		for p := range to {
+			if _, ok := oldIndex[p]; !ok {
+				removed = append(removed, p)
+			}
+		}
+		saveStaging(staged)
+		saveStagedRemovals(removed)
+	case "mixed":
+		os.Remove(STAGING_FILE)
+		os.Remove(STAGING_REMOVED_FILE)
+		clearMergeState()
+		dropStoppedPick()
+	case "hard":
+		if err := updateWorkingTree(oldIndex, to); err != nil {
+			fmt.Println("Error updating working tree:", err)
+			return
+		}
+		for p := range commitFiles(head) {
+			if _, ok := to[p]; !ok {
+				os.Remove(p)
+			}
+		}
+		os.Remove(STAGING_FILE)
+		os.Remove(STAGING_REMOVED_FILE)
+		clearMergeState()
+		dropStoppedPick()
+	}
+
+	updateBranch(currentBranch(), target, "reset: moving to "+rev)
+	switch mode {
+	case "hard":
+		c, err := readCommit(target)
+		if err == nil {
+			fmt.Printf("HEAD is now at %s %s\n", shortID(target), subjectLine(c.Message))
+		}
+	case "mixed":
+		var changed []string
+		work := getWorkingFiles()
+		for p, content := range to {
+			if disk, ok := work[p]; !ok || disk != content {
+				changed = append(changed, p)
+			}
+		}
+		if len(changed) > 0 {
+			sort.Strings(changed)
+			fmt.Println("Unstaged changes after reset:")
+			for _, p := range changed {
+				if _, ok := work[p]; ok {
+					fmt.Println("M\t" + p)
+				} else {
+					fmt.Println("D\t" + p)
+				}
+			}
+		}
+	}
+}
+
+// resetPaths sets the staged version of each path to its content at target,
+// which for target HEAD unstages it. The working tree is not touched.
+func resetPaths(target string, paths []string) {
+	headFiles := getLastCommitFiles()
+	to := commitFiles(target)
+	staged := loadStaging()
+	var removed []string
+	for _, p := range loadStagedRemovals() {
+		if !containsString(paths, p) {
+			removed = append(removed, p)
+		}
+	}
+	for _, p := range paths {
+		content, inTarget := to[p]
+		old, inHead := headFiles[p]
+		_, wasStaged := staged[p]
+		if !inTarget && !inHead && !wasStaged {
+			fmt.Println("Path not in the index or", shortID(target)+":", p)
+			continue
+		}
+		delete(staged, p)
+		switch {
+		case inTarget && (!inHead || old != content):
+			staged[p] = content
+		case !inTarget && inHead:
+			removed = append(removed, p)
+		}
+		fmt.Println("Unstaged:", p)
+	}
+	saveStaging(staged)
+	saveStagedRemovals(removed)
+}
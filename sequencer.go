@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ----------------------------------------
+   Sequencer: revert and cherry-pick a series of commits
+-------------------------------------------*/
+
+// A revert or cherry-pick of several commits runs one commit at a time. The
+// remaining work is kept in .gud/sequencer so that, when a commit does not
+// apply cleanly, the user can resolve the conflicts and run "--continue" (or
+// give up with "--abort"). REVERT_HEAD or CHERRY_PICK_HEAD names the commit
+// that stopped.
+
+const (
+	SEQUENCER_FILE        = ".gud/sequencer"
+	REVERT_HEAD_FILE      = ".gud/REVERT_HEAD"
+	CHERRY_PICK_HEAD_FILE = ".gud/CHERRY_PICK_HEAD"
+)
+
+type sequencerState struct {
+	Command      string   `json:"command"` // "revert" or "cherry-pick"
+	Head         string   `json:"head"`    // HEAD before the sequence, for --abort
+	Todo         []string `json:"todo"`
+	Mainline     int      `json:"mainline,omitempty"`
+	NoCommit     bool     `json:"no_commit,omitempty"`
+	RecordOrigin bool     `json:"record_origin,omitempty"` // cherry-pick -x
+}
+
+func loadSequencer() (*sequencerState, bool) {
+	data, err := os.ReadFile(SEQUENCER_FILE)
+	if err != nil {
+		return nil, false
+	}
+	var s sequencerState
+	if json.Unmarshal(data, &s) != nil {
+		return nil, false
+	}
+	return &s, true
+}
+
+func saveSequencer(s *sequencerState) {
+	data, _ := json.MarshalIndent(s, "", "  ")
+	os.WriteFile(SEQUENCER_FILE, data, 0644)
+}
+
+func clearSequencer() {
+	os.Remove(SEQUENCER_FILE)
+	os.Remove(REVERT_HEAD_FILE)
+	os.Remove(CHERRY_PICK_HEAD_FILE)
+}
+
+// pickStopped reports whether a revert or cherry-pick is waiting for its
+// stopped commit to be resolved and committed.
+func pickStopped() bool {
+	for _, f := range []string{REVERT_HEAD_FILE, CHERRY_PICK_HEAD_FILE} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// finishStoppedPick marks the stopped commit as done, whether it was
+// committed by --continue or by a plain "gud commit", so that --continue
+// goes on with the next one and --abort still knows where HEAD was.
+func finishStoppedPick() {
+	os.Remove(REVERT_HEAD_FILE)
+	os.Remove(CHERRY_PICK_HEAD_FILE)
+	state, ok := loadSequencer()
+	if !ok {
+		return
+	}
+	if len(state.Todo) > 0 {
+		state.Todo = state.Todo[1:]
+	}
+	if len(state.Todo) == 0 {
+		clearSequencer()
+		return
+	}
+	saveSequencer(state)
+}
+
+// dropStoppedPick forgets the stopped commit after a reset threw its
+// result away, as if it had been skipped. The sequencer stays, so --continue
+// goes on with the next commit and --abort still restores the original HEAD.
+func dropStoppedPick() {
+	if !pickStopped() {
+		return
+	}
+	os.Remove(REVERT_HEAD_FILE)
+	os.Remove(CHERRY_PICK_HEAD_FILE)
+	if state, ok := loadSequencer(); ok && len(state.Todo) > 0 {
+		state.Todo = state.Todo[1:]
+		saveSequencer(state)
+	}
+}
+
+// stopFile is where a stopped revert or cherry-pick records its commit.
+func stopFile(command string) string {
+	if command == "revert" {
+		return REVERT_HEAD_FILE
+	}
+	return CHERRY_PICK_HEAD_FILE
+}
+
+// revertCommand handles "[--no-commit] [-m <parent>] <rev>...",
+// "--continue" and "--abort".
+func revertCommand(args []string) {
+	sequencerCommand("revert", args)
+}
+
+// cherryPickCommand handles "[-x] [--no-commit] [-m <parent>] <rev>...",
+// "--continue" and "--abort".
+func cherryPickCommand(args []string) {
+	sequencerCommand("cherry-pick", args)
+}
+
+func sequencerCommand(command string, args []string) {
+	state := &sequencerState{Command: command}
+	var revs []string
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "--continue" || a == "--abort":
+			if len(args) != 1 {
+				fmt.Printf("Usage: gud %s %s\n", command, a)
+				return
+			}
+			if a == "--continue" {
+				continueSequencer(command)
+			} else {
+				abortSequencer(command)
+			}
+			return
+		case a == "-x" && command == "cherry-pick":
+			state.RecordOrigin = true
+		case a == "--no-commit" || a == "-n":
+			state.NoCommit = true
+		case (a == "-m" || a == "--mainline") && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				fmt.Println("Invalid mainline parent:", args[i+1])
+				return
+			}
+			state.Mainline = n
+			i++
+		default:
+			revs = append(revs, a)
+		}
+	}
+	if len(revs) == 0 {
+		x := ""
+		if command == "cherry-pick" {
+			x = "[-x] "
+		}
+		fmt.Printf("Usage: gud %s %s[--no-commit] [-m <parent>] <revision>... | gud %s --continue | gud %s --abort\n", command, x, command, command)
+		return
+	}
+	startSequencer(state, revs)
+}
+
+// startSequencer resolves the revisions and runs them in order. A range
+// "A..B" stands for the commits of B that A does not contain: oldest first
+// for a cherry-pick, newest first for a revert.
+func startSequencer(state *sequencerState, revs []string) {
+	if _, ok := loadSequencer(); ok {
+		fmt.Printf("A %s is already in progress; use --continue or --abort.\n", state.Command)
+		return
+	}
+	if mergeInProgress() {
+		fmt.Println("A merge is in progress; commit the result or run 'gud merge --abort'.")
+		return
+	}
+	if !state.NoCommit && (len(loadStaging()) > 0 || len(loadStagedRemovals()) > 0) {
+		fmt.Printf("You have staged changes; commit or unstage them before you %s.\n", state.Command)
+		return
+	}
+	state.Head = currentBranchHead()
+	if state.Head == "" {
+		fmt.Println("HEAD has no commits yet.")
+		return
+	}
+	for _, rev := range revs {
+		if i := strings.Index(rev, ".."); i >= 0 {
+			from, err := resolveRevision(rev[:i])
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			tip := rev[i+2:]
+			if tip == "" {
+				tip = "HEAD"
+			}
+			to, err := resolveRevision(tip)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			commits := commitsToReplay(from, to)
+			for j := range commits {
+				c := commits[j]
+				if state.Command == "revert" {
+					c = commits[len(commits)-1-j]
+				}
+				state.Todo = append(state.Todo, c.ID)
+			}
+			continue
+		}
+		id, err := resolveRevision(rev)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		state.Todo = append(state.Todo, id)
+	}
+	if len(state.Todo) == 0 {
+		fmt.Println("Empty commit set passed.")
+		return
+	}
+	runSequencer(state)
+}
+
+// runSequencer applies the remaining commits until one stops on a conflict.
+func runSequencer(state *sequencerState) {
+	for len(state.Todo) > 0 {
+		saveSequencer(state)
+		if !pickCommit(state, state.Todo[0]) {
+			return
+		}
+		state.Todo = state.Todo[1:]
+	}
+	clearSequencer()
+}
+
+// pickCommit applies one commit of the sequence and, unless --no-commit,
+// commits the result. It returns false if the sequence has to stop.
+func pickCommit(state *sequencerState, id string) bool {
+	c, err := readCommit(id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		clearSequencer()
+		return false
+	}
+	parent, ok := pickParent(state, c)
+	if !ok {
+		clearSequencer()
+		return false
+	}
+
+	// A cherry-pick applies the change from parent to c; a revert applies
+	// the change from c back to parent.
+	subject := subjectLine(c.Message)
+	base, theirs := commitFiles(parent), c.Files
+	theirsLabel := shortID(id) + " (" + subject + ")"
+	author := c.Author
+	msg := c.Message
+	if state.RecordOrigin {
+		msg += fmt.Sprintf("\n\n(cherry picked from commit %s)", id)
+	}
+	if state.Command == "revert" {
+		base, theirs = c.Files, commitFiles(parent)
+		theirsLabel = "parent of " + theirsLabel
+		author = ""
+		msg = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject, id)
+		if len(c.Parents) > 1 {
+			msg += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+		}
+		msg += "."
+	}
+
+	ours := indexFiles(getLastCommitFiles())
+	result, conflicts := mergeTrees(base, ours, theirs, "HEAD", theirsLabel)
+
+	// Only files the pick changes are written; they must not carry unstaged
+	// modifications.
+	touched := touchedPaths(ours, result)
+	var blocked []string
+	for _, p := range touched {
+		want, tracked := ours[p]
+		disk, onDisk := readWorkingFile(p)
+		if onDisk != tracked || disk != want {
+			blocked = append(blocked, p)
+		}
+	}
+	if len(blocked) > 0 {
+		fmt.Printf("Your local changes would be overwritten by %s:\n", state.Command)
+		for _, p := range blocked {
+			fmt.Println("  ", p)
+		}
+		clearSequencer()
+		return false
+	}
+
+	staged := loadStaging()
+	removed := loadStagedRemovals()
+	headFiles := getLastCommitFiles()
+	for _, p := range touched {
+		content, keep := result[p]
+		if !keep {
+			os.Remove(p)
+		} else if err := writeWorkingFile(p, content); err != nil {
+			fmt.Println("Error updating working tree:", err)
+			clearSequencer()
+			return false
+		}
+		if containsString(conflicts, p) {
+			continue
+		}
+		delete(staged, p)
+		var kept []string
+		for _, r := range removed {
+			if r != p {
+				kept = append(kept, r)
+			}
+		}
+		removed = kept
+		if old, inHead := headFiles[p]; keep && (!inHead || old != content) {
+			staged[p] = content
+		} else if !keep && inHead {
+			removed = append(removed, p)
+		}
+	}
+	saveStaging(staged)
+	saveStagedRemovals(removed)
+
+	if len(conflicts) > 0 {
+		stopSequencer(state.Command, id, msg)
+		data, _ := json.MarshalIndent(conflicts, "", "  ")
+		os.WriteFile(MERGE_CONFLICTS_FILE, data, 0644)
+		for _, p := range conflicts {
+			fmt.Println("CONFLICT (content): Merge conflict in", p)
+		}
+		fmt.Printf("error: could not %s %s... %s\n", state.Command, shortID(id), subject)
+		fmt.Printf("hint: resolve the conflicts, mark them with 'gud add <path>' and run 'gud %s --continue'.\n", state.Command)
+		return false
+	}
+	if state.NoCommit {
+		return true
+	}
+	if len(staged) == 0 && len(removed) == 0 {
+		fmt.Printf("Nothing to commit for %s of %s; skipping.\n", state.Command, shortID(id))
+		return true
+	}
+	head := currentBranchHead()
+	createCommit(msg, author, "", configBool("commit.gpgSign", false), true)
+	if currentBranchHead() == head {
+		// The commit was refused (by a hook, say); --continue retries it.
+		stopSequencer(state.Command, id, msg)
+		return false
+	}
+	return true
+}
+
+// stopSequencer records the commit the sequence stopped at and the message
+// to commit it with.
+func stopSequencer(command, id, msg string) {
+	os.WriteFile(stopFile(command), []byte(id), 0644)
+	os.WriteFile(MERGE_MSG_FILE, []byte(msg), 0644)
+}
+
+// pickParent chooses the parent to diff against: the only one, the -m
+// mainline of a merge commit, or "" (the empty tree) for a root commit.
+func pickParent(state *sequencerState, c *Commit) (string, bool) {
+	switch {
+	case len(c.Parents) == 0 && state.Mainline == 0:
+		return "", true
+	case len(c.Parents) > 1 && state.Mainline == 0:
+		fmt.Printf("Commit %s is a merge but no -m option was given.\n", shortID(c.ID))
+		return "", false
+	case state.Mainline > len(c.Parents):
+		fmt.Printf("Commit %s does not have parent %d.\n", shortID(c.ID), state.Mainline)
+		return "", false
+	case state.Mainline > 0:
+		return c.Parents[state.Mainline-1], true
+	}
+	return c.Parents[0], true
+}
+
+// continueSequencer commits the resolved commit, if any, and carries on
+// with the rest of the sequence.
+func continueSequencer(command string) {
+	state, ok := loadSequencer()
+	if !ok || state.Command != command {
+		fmt.Printf("No %s in progress.\n", command)
+		return
+	}
+	if data, err := os.ReadFile(stopFile(command)); err == nil {
+		var unresolved []string
+		staged := loadStaging()
+		removed := loadStagedRemovals()
+		for _, p := range loadMergeConflicts() {
+			if _, ok := staged[p]; !ok && !containsString(removed, p) {
+				unresolved = append(unresolved, p)
+			}
+		}
+		if len(unresolved) > 0 {
+			sort.Strings(unresolved)
+			fmt.Println("Unresolved conflicts in", strings.Join(unresolved, ", "))
+			fmt.Println("Resolve them and 'gud add' each file first.")
+			return
+		}
+		id := strings.TrimSpace(string(data))
+		msg, _ := os.ReadFile(MERGE_MSG_FILE)
+		if !state.NoCommit && (len(staged) > 0 || len(removed) > 0) {
+			// The commit finishes the stopped pick (see createCommit).
+			head := currentBranchHead()
+			createCommit(string(msg), "", "", configBool("commit.gpgSign", false), true)
+			if currentBranchHead() == head {
+				return
+			}
+		} else {
+			if !state.NoCommit {
+				fmt.Printf("Nothing to commit for %s of %s; skipping.\n", command, shortID(id))
+			}
+			clearMergeState()
+			finishStoppedPick()
+		}
+		if state, ok = loadSequencer(); !ok {
+			return
+		}
+	}
+	runSequencer(state)
+}
+
+// abortSequencer returns the branch, index and working tree to where they
+// were before the sequence started.
+func abortSequencer(command string) {
+	state, ok := loadSequencer()
+	if !ok || state.Command != command {
+		fmt.Printf("No %s in progress.\n", command)
+		return
+	}
+	clearSequencer()
+	resetBranch("hard", "HEAD before "+command, state.Head)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRepo initializes a repository in a fresh working directory.
+func newTestRepo(t *testing.T) {
+	t.Helper()
+	t.Setenv("GUD_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "global"))
+	t.Setenv("GUD_CONFIG_SYSTEM", filepath.Join(t.TempDir(), "system"))
+	t.Chdir(t.TempDir())
+	initRepo()
+}
+
+// commitFilesForTest writes and stages files and commits them.
+func commitFilesForTest(t *testing.T, msg string, files map[string]string) string {
+	t.Helper()
+	for p, content := range files {
+		if err := writeWorkingFile(p, content); err != nil {
+			t.Fatal(err)
+		}
+		addFileToStaging(p)
+	}
+	head := currentBranchHead()
+	createCommit(msg, "", "", false, false)
+	if currentBranchHead() == head {
+		t.Fatalf("commit %q was not made", msg)
+	}
+	return currentBranchHead()
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// stoppedRevert sets up a revert of two commits that stops on the first,
+// resolves the conflict and commits the resolution with a plain commit. It
+// returns the HEAD before the revert and the commit that was to follow.
+func stoppedRevert(t *testing.T) (string, string) {
+	t.Helper()
+	newTestRepo(t)
+	commitFilesForTest(t, "base", map[string]string{"f": "a\nb\nc\n", "g": "x\n"})
+	changeB := commitFilesForTest(t, "change b", map[string]string{"f": "a\nB\nc\n"})
+	commitFilesForTest(t, "change B again", map[string]string{"f": "a\nBB\nc\n"})
+	changeG := commitFilesForTest(t, "change g", map[string]string{"g": "y\n"})
+
+	revertCommand([]string{changeB, changeG})
+	if _, err := os.Stat(REVERT_HEAD_FILE); err != nil {
+		t.Fatal("revert did not stop on the conflicting commit")
+	}
+	commitFilesForTest(t, "resolve the revert by hand", map[string]string{"f": "a\nb2\nc\n"})
+
+	if _, err := os.Stat(REVERT_HEAD_FILE); err == nil {
+		t.Error("REVERT_HEAD is left after the stopped commit was committed")
+	}
+	state, ok := loadSequencer()
+	if !ok {
+		t.Fatal("a plain commit ended the whole revert")
+	}
+	if len(state.Todo) != 1 || state.Todo[0] != changeG {
+		t.Fatalf("todo after the manual commit = %v, want [%s]", state.Todo, changeG)
+	}
+	return state.Head, changeG
+}
+
+func TestRevertContinueAfterManualCommit(t *testing.T) {
+	stoppedRevert(t)
+	resolved := currentBranchHead()
+
+	revertCommand([]string{"--continue"})
+	if _, ok := loadSequencer(); ok {
+		t.Error("sequencer left behind after --continue finished")
+	}
+	head, err := readCommit(currentBranchHead())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(head.Parents) != 1 || head.Parents[0] != resolved {
+		t.Errorf("--continue did not revert the remaining commit on top of the resolution")
+	}
+	if got := readTestFile(t, "g"); got != "x\n" {
+		t.Errorf("g = %q after reverting its change, want %q", got, "x\n")
+	}
+	if got := readTestFile(t, "f"); got != "a\nb2\nc\n" {
+		t.Errorf("f = %q, want the manual resolution", got)
+	}
+}
+
+func TestRevertAbortAfterManualCommit(t *testing.T) {
+	original, _ := stoppedRevert(t)
+
+	revertCommand([]string{"--abort"})
+	if got := currentBranchHead(); got != original {
+		t.Errorf("HEAD = %s after --abort, want %s", shortID(got), shortID(original))
+	}
+	if got := readTestFile(t, "f"); got != "a\nBB\nc\n" {
+		t.Errorf("f = %q after --abort, want the original content", got)
+	}
+	if _, ok := loadSequencer(); ok {
+		t.Error("sequencer left behind after --abort")
+	}
+}
+
+func TestCherryPickStatusSurvivesManualCommit(t *testing.T) {
+	newTestRepo(t)
+	commitFilesForTest(t, "base", map[string]string{"f": "a\n"})
+	checkoutCommand([]string{"-b", "topic"})
+	one := commitFilesForTest(t, "one", map[string]string{"f": "one\n"})
+	two := commitFilesForTest(t, "two", map[string]string{"h": "two\n"})
+	checkoutCommand([]string{"main"})
+	commitFilesForTest(t, "main change", map[string]string{"f": "main\n"})
+
+	cherryPickCommand([]string{one, two})
+	if _, err := os.Stat(CHERRY_PICK_HEAD_FILE); err != nil {
+		t.Fatal("cherry-pick did not stop on the conflict")
+	}
+	commitFilesForTest(t, "take one", map[string]string{"f": "main and one\n"})
+	if _, ok := loadSequencer(); !ok {
+		t.Fatal("a plain commit ended the cherry-pick")
+	}
+	cherryPickCommand([]string{"--continue"})
+	if got := readTestFile(t, "h"); got != "two\n" {
+		t.Errorf("h = %q, want the second pick applied", got)
+	}
+	if _, ok := loadSequencer(); ok {
+		t.Error("sequencer left behind after --continue finished")
+	}
+}
+
+func TestResetDropsStoppedPick(t *testing.T) {
+	for _, mode := range []string{"hard", "mixed"} {
+		t.Run(mode, func(t *testing.T) {
+			newTestRepo(t)
+			commitFilesForTest(t, "base", map[string]string{"f": "a\n"})
+			checkoutCommand([]string{"-b", "topic"})
+			one := commitFilesForTest(t, "one", map[string]string{"f": "one\n"})
+			two := commitFilesForTest(t, "two", map[string]string{"h": "two\n"})
+			checkoutCommand([]string{"main"})
+			original := commitFilesForTest(t, "main change", map[string]string{"f": "main\n"})
+
+			cherryPickCommand([]string{one, two})
+			if !pickStopped() {
+				t.Fatal("cherry-pick did not stop on the conflict")
+			}
+			resetBranch(mode, "HEAD", original)
+			if pickStopped() {
+				t.Fatal("CHERRY_PICK_HEAD is left after reset")
+			}
+			state, ok := loadSequencer()
+			if !ok || state.Head != original || len(state.Todo) != 1 || state.Todo[0] != two {
+				t.Fatalf("sequencer after reset = %+v, %v; want it kept with [%s] left", state, ok, two)
+			}
+
+			if mode == "mixed" {
+				resetBranch("hard", "HEAD", original)
+			}
+			cherryPickCommand([]string{"--continue"})
+			if got := readTestFile(t, "h"); got != "two\n" {
+				t.Errorf("h = %q, want the remaining pick applied", got)
+			}
+			if got := readTestFile(t, "f"); got != "main\n" {
+				t.Errorf("f = %q, want the dropped pick left out", got)
+			}
+			if _, ok := loadSequencer(); ok {
+				t.Error("sequencer left behind after --continue finished")
+			}
+		})
+	}
+}
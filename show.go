@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* ----------------------------------------
+   gud show: commits, tags and files at revisions
+-------------------------------------------*/
+
+func showUsage() {
+	fmt.Println("Usage: gud show [-s|--no-patch] [--oneline] [--format=<template>] [--show-signature] [-M[<n>]] [-C[<n>]] [-w] [--word-diff[=<mode>]] [<object>...]")
+	fmt.Println("       <object> is a revision, a tag, <revision>:<path> or :<path> (the staged version)")
+}
+
+// showCommand prints each object: a commit's header and its changes against
+// its parent, an annotated tag's header followed by the commit it names, or
+// the raw content of a file at a revision.
+func showCommand(args []string) {
+	args, diff, err := diffFlags(args, defaultDiffOptions())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	opts := &logOptions{format: "medium", decorate: true, max: -1, diff: diff}
+	patch := true
+	var objects []string
+	for _, a := range args {
+		switch {
+		case a == "-s" || a == "--no-patch":
+			patch = false
+		case a == "-p" || a == "--patch":
+			patch = true
+		case a == "--oneline":
+			opts.format = "oneline"
+		case strings.HasPrefix(a, "--format=") || strings.HasPrefix(a, "--pretty="):
+			opts.format = prettyFormat(a[strings.Index(a, "=")+1:])
+		case a == "--show-signature":
+			opts.showSignature = true
+		case strings.HasPrefix(a, "-") && a != "-":
+			fmt.Println("Error: unknown option:", a)
+			showUsage()
+			return
+		default:
+			objects = append(objects, a)
+		}
+	}
+	if len(objects) == 0 {
+		objects = []string{"HEAD"}
+	}
+
+	var signers map[string]string
+	if opts.showSignature {
+		var err error
+		if signers, err = loadAllowedSigners(); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	decor := decorations()
+	for n, obj := range objects {
+		if n > 0 && opts.format != "oneline" {
+			fmt.Println()
+		}
+		if i := strings.Index(obj, ":"); i >= 0 {
+			if err := showFile(obj[:i], obj[i+1:]); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			continue
+		}
+		id := obj
+		if tagID, ok := loadTags()[obj]; ok {
+			id = showTagObjects(tagID, signers)
+		} else {
+			var err error
+			if id, err = resolveRevision(obj); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+		}
+		if err := showCommit(opts, id, patch, decor, signers); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+}
+
+// showFile prints a file as it is at rev, or as staged when rev is empty.
+func showFile(rev, path string) error {
+	path = strings.TrimPrefix(path, "./")
+	var files map[string]string
+	where := "the index"
+	if rev == "" {
+		files = indexFiles(getLastCommitFiles())
+	} else {
+		id, err := resolveRevision(rev)
+		if err != nil {
+			return err
+		}
+		files = commitFiles(id)
+		where = "'" + rev + "'"
+	}
+	content, ok := files[path]
+	if !ok {
+		return fmt.Errorf("path '%s' does not exist in %s", path, where)
+	}
+	fmt.Print(content)
+	return nil
+}
+
+// showTagObjects prints the annotated tags starting at id, following tags
+// of tags, and returns the commit they lead to. A lightweight tag prints
+// nothing.
+func showTagObjects(id string, signers map[string]string) string {
+	for depth := 0; depth < 10; depth++ {
+		t, err := readTagObjectAt(GUD_DIR, id)
+		if err != nil {
+			break
+		}
+		fmt.Printf("tag %s\nTagger: %s\nDate:   %s\n\n%s\n", t.Tag, t.Tagger, formatDate(t.Timestamp), t.Message)
+		if signers != nil && t.Signature != nil {
+			fmt.Println(verifyTag(t, signers))
+		}
+		fmt.Println()
+		id = t.Object
+	}
+	return id
+}
+
+// showCommit prints a commit in the chosen format followed by its patch
+// against its parent. Merges show no patch, since no single parent
+// describes their changes.
+func showCommit(opts *logOptions, id string, patch bool, decor map[string][]string, signers map[string]string) error {
+	c, err := readCommit(id)
+	if err != nil {
+		return err
+	}
+	for _, l := range formatCommit(opts, c, decor, signers) {
+		fmt.Println(l)
+	}
+	if !patch || len(c.Parents) > 1 {
+		return nil
+	}
+	var parent map[string]string
+	if len(c.Parents) == 1 {
+		parent = commitFiles(c.Parents[0])
+	}
+	if p := treePatch(parent, c.Files, opts.diff); p != "" {
+		if opts.format != "oneline" && !strings.HasPrefix(opts.format, "format:") {
+			fmt.Println()
+		}
+		fmt.Print(p)
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* ----------------------------------------
+   Signed commits and tags (Ed25519 / OpenSSH keys)
+-------------------------------------------*/
+
+// A signature covers the canonical payload of a commit or tag: its JSON
+// encoding with the ID and signature left empty, prefixed with the object
+// kind. The signed object's ID is computed afterwards, so it also covers
+// the signature.
+//
+// user.signingKey names the private key: an unencrypted OpenSSH ed25519 key
+// (as made by ssh-keygen -t ed25519) or a PKCS#8 PEM ed25519 key.
+// gpg.ssh.allowedSignersFile names an OpenSSH allowed-signers file listing
+// the principals trusted to sign, one "principals [options] ssh-ed25519 key"
+// per line.
+
+const SSH_ED25519 = "ssh-ed25519"
+
+type Signature struct {
+	Key string `json:"key"` // public key in authorized_keys form
+	Sig string `json:"sig"` // base64 Ed25519 signature
+}
+
+// signFlag removes -S/--gpg-sign and --no-gpg-sign from args and reports
+// whether to sign, starting from def.
+func signFlag(args []string, def bool) ([]string, bool) {
+	var rest []string
+	sign := def
+	for _, a := range args {
+		switch a {
+		case "-S", "--gpg-sign":
+			sign = true
+		case "--no-gpg-sign":
+			sign = false
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest, sign
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// sshString reads one length-prefixed field of the SSH wire format.
+func sshString(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("truncated key data")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return nil, nil, fmt.Errorf("truncated key data")
+	}
+	return data[4 : 4+n], data[4+n:], nil
+}
+
+func appendSSHString(buf []byte, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// parseOpenSSHPrivateKey decodes an unencrypted "openssh-key-v1" ed25519 key.
+func parseOpenSSHPrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	magic := []byte("openssh-key-v1\x00")
+	if !bytes.HasPrefix(der, magic) {
+		return nil, fmt.Errorf("not an OpenSSH private key")
+	}
+	rest := der[len(magic):]
+	var cipher, kdf, privBlock []byte
+	var err error
+	if cipher, rest, err = sshString(rest); err != nil {
+		return nil, err
+	}
+	if kdf, rest, err = sshString(rest); err != nil {
+		return nil, err
+	}
+	if string(cipher) != "none" || string(kdf) != "none" {
+		return nil, fmt.Errorf("encrypted keys are not supported")
+	}
+	if _, rest, err = sshString(rest); err != nil { // kdf options
+		return nil, err
+	}
+	if len(rest) < 4 || binary.BigEndian.Uint32(rest) != 1 {
+		return nil, fmt.Errorf("expected exactly one key")
+	}
+	rest = rest[4:]
+	if _, rest, err = sshString(rest); err != nil { // public key
+		return nil, err
+	}
+	if privBlock, _, err = sshString(rest); err != nil {
+		return nil, err
+	}
+
+	if len(privBlock) < 8 || binary.BigEndian.Uint32(privBlock) != binary.BigEndian.Uint32(privBlock[4:]) {
+		return nil, fmt.Errorf("corrupt private key")
+	}
+	rest = privBlock[8:]
+	var keyType, priv []byte
+	if keyType, rest, err = sshString(rest); err != nil {
+		return nil, err
+	}
+	if string(keyType) != SSH_ED25519 {
+		return nil, fmt.Errorf("unsupported key type %s (only ed25519 keys are supported)", keyType)
+	}
+	if _, rest, err = sshString(rest); err != nil { // public key again
+		return nil, err
+	}
+	if priv, _, err = sshString(rest); err != nil {
+		return nil, err
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("corrupt ed25519 key")
+	}
+	return ed25519.PrivateKey(priv), nil
+}
+
+// loadSigningKey reads the private key named by user.signingKey.
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	path := configString("user.signingKey", "")
+	if path == "" {
+		return nil, fmt.Errorf("no signing key configured (set user.signingKey)")
+	}
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := key.(ed25519.PrivateKey); ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+	return nil, fmt.Errorf("unsupported signing key format: %s", block.Type)
+}
+
+func sshPublicKeyWire(pub ed25519.PublicKey) []byte {
+	return appendSSHString(appendSSHString(nil, []byte(SSH_ED25519)), pub)
+}
+
+func marshalSSHPublicKey(pub ed25519.PublicKey) string {
+	return SSH_ED25519 + " " + base64.StdEncoding.EncodeToString(sshPublicKeyWire(pub))
+}
+
+// parseSSHPublicKey accepts the "ssh-ed25519 AAAA..." form.
+func parseSSHPublicKey(keyType, encoded string) (ed25519.PublicKey, error) {
+	if keyType != SSH_ED25519 {
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+	wire, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("bad public key encoding")
+	}
+	name, rest, err := sshString(wire)
+	if err != nil || string(name) != SSH_ED25519 {
+		return nil, fmt.Errorf("bad public key")
+	}
+	key, _, err := sshString(rest)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("bad public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// keyFingerprint matches ssh-keygen -l: SHA256 of the wire form, base64.
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(sshPublicKeyWire(pub))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func signedMessage(kind string, payload []byte) []byte {
+	return append([]byte("gud "+kind+"\n"), payload...)
+}
+
+func signPayload(kind string, payload []byte) (*Signature, error) {
+	key, err := loadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(key, signedMessage(kind, payload))
+	return &Signature{
+		Key: marshalSSHPublicKey(key.Public().(ed25519.PublicKey)),
+		Sig: base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+func commitPayload(c *Commit) []byte {
+	payload := *c
+	payload.ID = ""
+	payload.Signature = nil
+	data, _ := json.Marshal(&payload)
+	return data
+}
+
+func tagPayload(t *TagObject) []byte {
+	payload := *t
+	payload.ID = ""
+	payload.Signature = nil
+	data, _ := json.Marshal(&payload)
+	return data
+}
+
+func signCommit(c *Commit) error {
+	sig, err := signPayload("commit", commitPayload(c))
+	if err != nil {
+		return err
+	}
+	c.Signature = sig
+	return nil
+}
+
+func signTag(t *TagObject) error {
+	sig, err := signPayload("tag", tagPayload(t))
+	if err != nil {
+		return err
+	}
+	t.Signature = sig
+	return nil
+}
+
+// loadAllowedSigners maps key fingerprints to the principals allowed to sign
+// with them.
+func loadAllowedSigners() (map[string]string, error) {
+	signers := make(map[string]string)
+	path := configString("gpg.ssh.allowedSignersFile", "")
+	if path == "" {
+		return signers, nil
+	}
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("reading allowed signers: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// principals [options] keytype key [comment]
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == SSH_ED25519 {
+				if pub, err := parseSSHPublicKey(fields[i], fields[i+1]); err == nil {
+					signers[keyFingerprint(pub)] = fields[0]
+				}
+				break
+			}
+		}
+	}
+	return signers, scanner.Err()
+}
+
+type verification struct {
+	Signed      bool
+	Valid       bool // the signature matches the payload
+	Principal   string
+	Fingerprint string
+	Err         string
+}
+
+func (v verification) ok() bool {
+	return v.Signed && v.Valid && v.Principal != ""
+}
+
+func (v verification) String() string {
+	switch {
+	case !v.Signed:
+		return "No signature"
+	case v.Err != "":
+		return "BAD signature: " + v.Err
+	case !v.Valid:
+		return fmt.Sprintf("BAD signature made with key %s", v.Fingerprint)
+	case v.Principal == "":
+		return fmt.Sprintf("Good signature with key %s, but no principal in the allowed signers file matches it", v.Fingerprint)
+	}
+	return fmt.Sprintf("Good %q signature for %s with key %s", SSH_ED25519, v.Principal, v.Fingerprint)
+}
+
+func verifySignature(kind string, payload []byte, sig *Signature, signers map[string]string) verification {
+	if sig == nil {
+		return verification{}
+	}
+	v := verification{Signed: true}
+	fields := strings.Fields(sig.Key)
+	if len(fields) < 2 {
+		v.Err = "malformed signing key"
+		return v
+	}
+	pub, err := parseSSHPublicKey(fields[0], fields[1])
+	if err != nil {
+		v.Err = err.Error()
+		return v
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig.Sig)
+	if err != nil {
+		v.Err = "malformed signature"
+		return v
+	}
+	v.Fingerprint = keyFingerprint(pub)
+	v.Valid = ed25519.Verify(pub, signedMessage(kind, payload), raw)
+	v.Principal = signers[v.Fingerprint]
+	return v
+}
+
+func verifyCommit(c *Commit, signers map[string]string) verification {
+	return verifySignature("commit", commitPayload(c), c.Signature, signers)
+}
+
+func verifyTag(t *TagObject, signers map[string]string) verification {
+	return verifySignature("tag", tagPayload(t), t.Signature, signers)
+}
+
+func verifyCommitCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: gud verify-commit <revision>...")
+		os.Exit(1)
+	}
+	signers, err := loadAllowedSigners()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, rev := range args {
+		id, err := resolveRevision(rev)
+		if err == nil {
+			var c *Commit
+			if c, err = readCommit(id); err == nil {
+				v := verifyCommit(c, signers)
+				fmt.Printf("%s: %s\n", shortID(id), v)
+				failed = failed || !v.ok()
+				continue
+			}
+		}
+		fmt.Println("Error:", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func verifyTagCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: gud verify-tag <tag>...")
+		os.Exit(1)
+	}
+	signers, err := loadAllowedSigners()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, name := range args {
+		id, ok := loadTags()[name]
+		if !ok {
+			fmt.Println("Tag not found:", name)
+			failed = true
+			continue
+		}
+		t, err := readTagObjectAt(GUD_DIR, id)
+		if err != nil {
+			fmt.Printf("%s: lightweight tags cannot be signed\n", name)
+			failed = true
+			continue
+		}
+		v := verifyTag(t, signers)
+		fmt.Printf("%s: %s\n", name, v)
+		failed = failed || !v.ok()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* ----------------------------------------
+   SSH transport: gud-upload-pack / gud-receive-pack over stdin/stdout
+-------------------------------------------*/
+
+// The pack protocol is a stream of JSON values. The server first writes its
+// refAdvertisement, then answers each request the client writes (an
+// uploadRequest or a receiveRequest) with one response, until stdin closes.
+
+const (
+	UPLOAD_PACK_COMMAND  = "gud-upload-pack"
+	RECEIVE_PACK_COMMAND = "gud-receive-pack"
+)
+
+func uploadPackCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: gud upload-pack <path>")
+		os.Exit(1)
+	}
+	if err := serveUploadPack(args[0], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "upload-pack:", err)
+		os.Exit(1)
+	}
+}
+
+func receivePackCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: gud receive-pack <path>")
+		os.Exit(1)
+	}
+	if err := serveReceivePack(args[0], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "receive-pack:", err)
+		os.Exit(1)
+	}
+}
+
+func serveUploadPack(path string, in io.Reader, out io.Writer) error {
+	gudDir, err := localRemoteGudDir(path)
+	if err != nil {
+		return err
+	}
+	enc, dec := json.NewEncoder(out), json.NewDecoder(in)
+	if err := enc.Encode(advertiseRefsAt(gudDir)); err != nil {
+		return err
+	}
+	for {
+		var req uploadRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var resp uploadResponse
+		resp.Pack, err = uploadPackAt(gudDir, req.Wants, req.Haves, req.Depth)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func serveReceivePack(path string, in io.Reader, out io.Writer) error {
+	gudDir, err := localRemoteGudDir(path)
+	if err != nil {
+		return err
+	}
+	enc, dec := json.NewEncoder(out), json.NewDecoder(in)
+	if err := enc.Encode(advertiseRefsAt(gudDir)); err != nil {
+		return err
+	}
+	for {
+		var req receiveRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var resp receiveResponse
+		resp.Results, err = receiveObjectsAt(gudDir, req.Pack, req.Updates)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// parseSSHURL accepts ssh://[user@]host[:port]/path and the scp-like
+// [user@]host:path form.
+func parseSSHURL(url string) (host, port, path string, ok bool) {
+	if strings.HasPrefix(url, "ssh://") {
+		rest := strings.TrimPrefix(url, "ssh://")
+		i := strings.Index(rest, "/")
+		if i <= 0 {
+			return "", "", "", false
+		}
+		host, path = rest[:i], rest[i:]
+		if j := strings.LastIndex(host, ":"); j > 0 {
+			host, port = host[:j], host[j+1:]
+		}
+		// ssh://host/~/repo is relative to the remote home directory.
+		path = strings.TrimPrefix(path, "/~/")
+		return host, port, path, true
+	}
+	if strings.Contains(url, "://") {
+		return "", "", "", false
+	}
+	i := strings.Index(url, ":")
+	if i <= 1 || strings.Contains(url[:i], "/") {
+		// No host part, or a Windows drive letter such as C:.
+		return "", "", "", false
+	}
+	return url[:i], "", url[i+1:], true
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshTransport runs the remote pack command through ssh, or through
+// GUD_SSH_COMMAND when set (for example to test against a local command).
+type sshTransport struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	enc *json.Encoder
+	dec *json.Decoder
+	adv *refAdvertisement
+}
+
+func newSSHTransport(host, port, path string, forPush bool) (*sshTransport, error) {
+	service := UPLOAD_PACK_COMMAND
+	if forPush {
+		service = RECEIVE_PACK_COMMAND
+	}
+	sshCommand := os.Getenv("GUD_SSH_COMMAND")
+	if sshCommand == "" {
+		sshCommand = "ssh"
+	}
+	sshArgs := []string{}
+	if port != "" {
+		sshArgs = append(sshArgs, "-p", port)
+	}
+	sshArgs = append(sshArgs, host, service+" "+shellQuote(path))
+
+	cmd := exec.Command("sh", append([]string{"-c", sshCommand + ` "$@"`, sshCommand}, sshArgs...)...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	t := &sshTransport{cmd: cmd, in: in, enc: json.NewEncoder(in), dec: json.NewDecoder(out)}
+	var adv refAdvertisement
+	if err := t.dec.Decode(&adv); err != nil {
+		t.close()
+		return nil, fmt.Errorf("reading ref advertisement from %s: %v", host, err)
+	}
+	if adv.Refs == nil {
+		adv.Refs = make(map[string]string)
+	}
+	t.adv = &adv
+	return t, nil
+}
+
+func (t *sshTransport) listRefs() (*refAdvertisement, error) {
+	return t.adv, nil
+}
+
+func (t *sshTransport) fetchObjects(wants, haves []string, depth int) ([]byte, error) {
+	if err := t.enc.Encode(uploadRequest{wants, haves, depth}); err != nil {
+		return nil, err
+	}
+	var resp uploadResponse
+	if err := t.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Pack, nil
+}
+
+func (t *sshTransport) pushObjects(pack []byte, updates []refUpdate) ([]refUpdateResult, error) {
+	if err := t.enc.Encode(receiveRequest{pack, updates}); err != nil {
+		return nil, err
+	}
+	var resp receiveResponse
+	if err := t.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Results, nil
+}
+
+func (t *sshTransport) close() {
+	t.in.Close()
+	t.cmd.Wait()
+}
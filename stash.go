@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ----------------------------------------
+   Stash: gud stash push/pop/apply/list/drop/show/branch
+-------------------------------------------*/
+
+// A stash entry is a commit W whose files are the working tree (tracked
+// files only). Its first parent is the HEAD it was made on, its second an
+// index commit I holding what was staged and, with --include-untracked, a
+// third commit U holds the untracked files. .gud/refs/stash lists the
+// entries, newest first; stash@{0} is the first.
+
+const STASH_FILE = ".gud/refs/stash"
+
+func loadStash() []string {
+	return loadStashAt(GUD_DIR)
+}
+
+func loadStashAt(gudDir string) []string {
+	var ids []string
+	data, err := os.ReadFile(filepath.Join(gudDir, "refs", "stash"))
+	if err == nil {
+		json.Unmarshal(data, &ids)
+	}
+	return ids
+}
+
+func saveStash(ids []string) {
+	if len(ids) == 0 {
+		os.Remove(STASH_FILE)
+		return
+	}
+	os.MkdirAll(filepath.Dir(STASH_FILE), 0755)
+	data, _ := json.MarshalIndent(ids, "", "  ")
+	os.WriteFile(STASH_FILE, data, 0644)
+}
+
+// stashIndex parses "stash@{N}", "N" or "" (the latest entry).
+func stashIndex(ref string, stash []string) (int, error) {
+	n := 0
+	if ref != "" {
+		s := strings.TrimSuffix(strings.TrimPrefix(ref, "stash@{"), "}")
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			return 0, fmt.Errorf("%s is not a valid stash reference", ref)
+		}
+	}
+	if n >= len(stash) {
+		if len(stash) == 0 {
+			return 0, fmt.Errorf("no stash entries found")
+		}
+		return 0, fmt.Errorf("stash@{%d} does not exist", n)
+	}
+	return n, nil
+}
+
+// stashRevision resolves "stash" and "stash@{N}" for resolveRevision.
+func stashRevision(name string) (string, bool) {
+	if name != "stash" && !strings.HasPrefix(name, "stash@{") {
+		return "", false
+	}
+	if name == "stash" {
+		name = ""
+	}
+	stash := loadStash()
+	n, err := stashIndex(name, stash)
+	if err != nil {
+		return "", false
+	}
+	return stash[n], true
+}
+
+// indexFiles applies the staging area to a snapshot: the files the next
+// commit would contain.
+func indexFiles(head map[string]string) map[string]string {
+	files := make(map[string]string)
+	for k, v := range head {
+		files[k] = v
+	}
+	for k, v := range loadStaging() {
+		files[k] = v
+	}
+	for _, k := range loadStagedRemovals() {
+		delete(files, k)
+	}
+	return files
+}
+
+func sameFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func handleStashCommand(args []string) {
+	if len(args) == 0 {
+		stashPush(nil)
+		return
+	}
+	sub, rest := args[0], args[1:]
+	switch sub {
+	case "push", "save":
+		stashPush(rest)
+	case "list":
+		stashList()
+	case "show":
+		stashShow(rest)
+	case "apply", "pop":
+		restoreIndex := false
+		var refs []string
+		for _, a := range rest {
+			if a == "--index" {
+				restoreIndex = true
+			} else {
+				refs = append(refs, a)
+			}
+		}
+		if len(refs) > 1 {
+			fmt.Printf("Usage: gud stash %s [--index] [<stash>]\n", sub)
+			return
+		}
+		stashApply(optionalArg(refs), restoreIndex, sub == "pop")
+	case "drop":
+		if len(rest) > 1 {
+			fmt.Println("Usage: gud stash drop [<stash>]")
+			return
+		}
+		stashDrop(optionalArg(rest))
+	case "clear":
+		saveStash(nil)
+	case "branch":
+		if len(rest) < 1 || len(rest) > 2 {
+			fmt.Println("Usage: gud stash branch <name> [<stash>]")
+			return
+		}
+		stashBranch(rest[0], optionalArg(rest[1:]))
+	default:
+		if strings.HasPrefix(sub, "-") {
+			stashPush(args)
+			return
+		}
+		fmt.Println("Unknown stash command:", sub)
+	}
+}
+
+// stashPush records the index and working tree as a stash entry and resets
+// both to HEAD.
+func stashPush(args []string) {
+	message := ""
+	untracked := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-u" || args[i] == "--include-untracked":
+			untracked = true
+		case args[i] == "-m" && i+1 < len(args):
+			message = args[i+1]
+			i++
+		default:
+			fmt.Println("Usage: gud stash push [-m <message>] [-u|--include-untracked]")
+			return
+		}
+	}
+	if mergeInProgress() {
+		fmt.Println("Cannot stash during a merge; commit the result or run 'gud merge --abort'.")
+		return
+	}
+	head := currentBranchHead()
+	if head == "" {
+		fmt.Println("You do not have the initial commit yet.")
+		return
+	}
+	headCommit, err := readCommit(head)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	headFiles := headCommit.Files
+	index := indexFiles(headFiles)
+	disk := getWorkingFiles()
+	work := make(map[string]string)
+	for p := range index {
+		if content, ok := disk[p]; ok {
+			work[p] = content
+		}
+	}
+	extra := make(map[string]string)
+	if untracked {
+		for p, content := range disk {
+			if _, ok := index[p]; !ok {
+				extra[p] = content
+			}
+		}
+	}
+	if sameFiles(index, headFiles) && sameFiles(work, index) && len(extra) == 0 {
+		fmt.Println("No local changes to save")
+		return
+	}
+
+	branch := currentBranch()
+	on := fmt.Sprintf("%s: %s %s", branch, shortID(head), subjectLine(headCommit.Message))
+	label := "WIP on " + on
+	if message != "" {
+		label = fmt.Sprintf("On %s: %s", branch, message)
+	}
+
+	i, err := commitTree(index, "index on "+on, []string{head}, "", false)
+	if err != nil {
+		fmt.Println("Error writing stash:", err)
+		return
+	}
+	parents := []string{head, i.ID}
+	if len(extra) > 0 {
+		u, err := commitTree(extra, "untracked files on "+on, nil, "", false)
+		if err != nil {
+			fmt.Println("Error writing stash:", err)
+			return
+		}
+		parents = append(parents, u.ID)
+	}
+	w, err := commitTree(work, label, parents, "", false)
+	if err != nil {
+		fmt.Println("Error writing stash:", err)
+		return
+	}
+	saveStash(append([]string{w.ID}, loadStash()...))
+
+	// Put the working tree and index back to HEAD.
+	for p := range index {
+		if _, ok := headFiles[p]; !ok {
+			os.Remove(p)
+		}
+	}
+	for p, content := range headFiles {
+		if old, ok := disk[p]; !ok || old != content {
+			if err := writeWorkingFile(p, content); err != nil {
+				fmt.Println("Error resetting working tree:", err)
+				return
+			}
+		}
+	}
+	for p := range extra {
+		os.Remove(p)
+	}
+	os.Remove(STAGING_FILE)
+	os.Remove(STAGING_REMOVED_FILE)
+	fmt.Println("Saved working directory and index state", label)
+}
+
+func stashList() {
+	for n, id := range loadStash() {
+		c, err := readCommit(id)
+		if err != nil {
+			fmt.Printf("stash@{%d}: %s (missing)\n", n, shortID(id))
+			continue
+		}
+		fmt.Printf("stash@{%d}: %s\n", n, c.Message)
+	}
+}
+
+// readStash loads an entry and the commits it was made from.
+func readStash(ref string) (int, *Commit, error) {
+	stash := loadStash()
+	n, err := stashIndex(ref, stash)
+	if err != nil {
+		return 0, nil, err
+	}
+	w, err := readCommit(stash[n])
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(w.Parents) < 2 {
+		return 0, nil, fmt.Errorf("stash@{%d} is not a stash entry", n)
+	}
+	return n, w, nil
+}
+
+// stashShow lists the files an entry changes relative to the commit it was
+// made on, or with -p prints the patch. Untracked files saved with the entry
+// are only included with -u.
+func stashShow(args []string) {
+	patch, untracked := false, false
+	var refs []string
+	for _, a := range args {
+		switch a {
+		case "-p", "--patch":
+			patch = true
+		case "-u", "--include-untracked":
+			untracked = true
+		default:
+			refs = append(refs, a)
+		}
+	}
+	if len(refs) > 1 {
+		fmt.Println("Usage: gud stash show [-p] [-u|--include-untracked] [<stash>]")
+		return
+	}
+	_, w, err := readStash(optionalArg(refs))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	base := commitFiles(w.Parents[0])
+	after := make(map[string]string)
+	for k, v := range w.Files {
+		after[k] = v
+	}
+	if untracked && len(w.Parents) > 2 {
+		for k, v := range commitFiles(w.Parents[2]) {
+			after[k] = v
+		}
+	}
+
+	paths := make(map[string]bool)
+	for p := range base {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+	var sorted []string
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for _, p := range sorted {
+		old, hasOld := base[p]
+		new, hasNew := after[p]
+		if hasOld == hasNew && old == new {
+			continue
+		}
+		if patch {
+			fmt.Print(filePatch(p, old, new, hasOld, hasNew, diffOptions{}))
+			continue
+		}
+		switch {
+		case !hasOld:
+			fmt.Println(" A", p)
+		case !hasNew:
+			fmt.Println(" D", p)
+		default:
+			fmt.Println(" M", p)
+		}
+	}
+}
+
+// stashApply merges an entry's working tree changes into the current
+// working tree. The entry was made on its first parent, which is the merge
+// base. With restoreIndex the staged changes are restored too. It returns
+// false if nothing was applied or the merge left conflicts.
+func stashApply(ref string, restoreIndex, pop bool) bool {
+	if mergeInProgress() {
+		fmt.Println("A merge is in progress; commit the result or run 'gud merge --abort'.")
+		return false
+	}
+	n, w, err := readStash(ref)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	base := commitFiles(w.Parents[0])
+	stashedIndex := commitFiles(w.Parents[1])
+	var untracked map[string]string
+	if len(w.Parents) > 2 {
+		untracked = commitFiles(w.Parents[2])
+	}
+
+	headFiles := getLastCommitFiles()
+	ours := indexFiles(headFiles)
+	result, conflicts := mergeTrees(base, ours, w.Files, "Updated upstream", "Stashed changes")
+
+	// Only paths the merge changes are written; they must not carry local
+	// modifications.
+	var touched, blocked []string
+	for p := range result {
+		if v, ok := ours[p]; !ok || v != result[p] {
+			touched = append(touched, p)
+		}
+	}
+	for p := range ours {
+		if _, ok := result[p]; !ok {
+			touched = append(touched, p)
+		}
+	}
+	sort.Strings(touched)
+	for _, p := range touched {
+		want, tracked := ours[p]
+		disk, onDisk := readWorkingFile(p)
+		if onDisk != tracked || disk != want {
+			blocked = append(blocked, p)
+		}
+	}
+	if len(blocked) > 0 {
+		fmt.Println("Your local changes to the following files would be overwritten by merge:")
+		for _, p := range blocked {
+			fmt.Println("  ", p)
+		}
+		fmt.Println("Commit or stash them first.")
+		return false
+	}
+	var existing []string
+	for p := range untracked {
+		if _, onDisk := readWorkingFile(p); onDisk {
+			existing = append(existing, p)
+		}
+	}
+	if len(existing) > 0 {
+		sort.Strings(existing)
+		for _, p := range existing {
+			fmt.Println(p, "already exists, no checkout")
+		}
+		fmt.Println("Could not restore untracked files from stash.")
+		return false
+	}
+
+	// The staged changes are reapplied only where the index has not moved
+	// on since the stash was made.
+	staged := loadStaging()
+	removed := loadStagedRemovals()
+	stage := func(p string, content string, present bool) {
+		if present {
+			staged[p] = content
+			var kept []string
+			for _, r := range removed {
+				if r != p {
+					kept = append(kept, r)
+				}
+			}
+			removed = kept
+			return
+		}
+		delete(staged, p)
+		if _, ok := headFiles[p]; ok && !containsString(removed, p) {
+			removed = append(removed, p)
+		}
+	}
+	if restoreIndex {
+		for _, p := range touchedPaths(base, stashedIndex) {
+			want, inI := stashedIndex[p]
+			have, inOurs := ours[p]
+			was, inBase := base[p]
+			if inOurs == inI && have == want {
+				continue
+			}
+			if inOurs != inBase || have != was {
+				fmt.Println("Conflicts in index. Try without --index.")
+				return false
+			}
+		}
+	}
+
+	for _, p := range touched {
+		content, keep := result[p]
+		if !keep {
+			os.Remove(p)
+		} else if err := writeWorkingFile(p, content); err != nil {
+			fmt.Println("Error updating working tree:", err)
+			return false
+		}
+		if containsString(conflicts, p) {
+			continue
+		}
+		// New and deleted files are staged so that they stay tracked.
+		if _, tracked := ours[p]; !tracked || !keep {
+			stage(p, content, keep)
+		}
+	}
+	if restoreIndex {
+		for _, p := range touchedPaths(base, stashedIndex) {
+			content, present := stashedIndex[p]
+			stage(p, content, present)
+		}
+	}
+	saveStaging(staged)
+	saveStagedRemovals(removed)
+	for p, content := range untracked {
+		if err := writeWorkingFile(p, content); err != nil {
+			fmt.Println("Error restoring untracked file:", err)
+			return false
+		}
+	}
+
+	if len(conflicts) > 0 {
+		for _, p := range conflicts {
+			fmt.Println("CONFLICT (content): Merge conflict in", p)
+		}
+		fmt.Println("The stash entry is kept in case you need it again.")
+		return false
+	}
+	status()
+	if pop {
+		dropStashEntry(n)
+	}
+	return true
+}
+
+// touchedPaths lists the paths whose content differs between two snapshots.
+func touchedPaths(a, b map[string]string) []string {
+	var paths []string
+	for p, v := range a {
+		if w, ok := b[p]; !ok || w != v {
+			paths = append(paths, p)
+		}
+	}
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func stashDrop(ref string) {
+	stash := loadStash()
+	n, err := stashIndex(ref, stash)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	dropStashEntry(n)
+}
+
+func dropStashEntry(n int) {
+	stash := loadStash()
+	id := stash[n]
+	saveStash(append(stash[:n], stash[n+1:]...))
+	fmt.Printf("Dropped stash@{%d} (%s)\n", n, id)
+}
+
+// stashBranch creates a branch at the commit an entry was made on, checks
+// it out and pops the entry there, where it applies without conflicts.
+func stashBranch(name, ref string) {
+	_, w, err := readStash(ref)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if !validBranchName(name) {
+		fmt.Println("Invalid branch name:", name)
+		return
+	}
+	branches := loadBranches()
+	if _, exists := branches[name]; exists {
+		fmt.Println("Branch already exists:", name)
+		return
+	}
+	updateBranch(name, w.Parents[0], "branch: Created from stash")
+	if !checkoutBranch(name) {
+		delete(branches, name)
+		saveBranches(branches)
+		return
+	}
+	stashApply(ref, true, true)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* ----------------------------------------
+   Tags: lightweight and annotated
+-------------------------------------------*/
+
+// .gud/tags maps each tag name to an object ID. A lightweight tag names a
+// commit directly; an annotated tag names a TagObject, which records who
+// tagged what, when and why, and points at a commit (or another tag).
+
+type TagObject struct {
+	ID        string     `json:"id"`
+	Object    string     `json:"object"`
+	Type      string     `json:"type"` // "commit" or "tag"
+	Tag       string     `json:"tag"`
+	Tagger    string     `json:"tagger"`
+	Timestamp string     `json:"timestamp"`
+	Message   string     `json:"message"`
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+func loadTagsAt(gudDir string) map[string]string {
+	tags := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(gudDir, "tags"))
+	if err == nil {
+		json.Unmarshal(data, &tags)
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	return tags
+}
+
+func saveTagsAt(gudDir string, tags map[string]string) {
+	data, _ := json.MarshalIndent(tags, "", "  ")
+	os.WriteFile(filepath.Join(gudDir, "tags"), data, 0644)
+}
+
+func loadTags() map[string]string {
+	return loadTagsAt(GUD_DIR)
+}
+
+func saveTags(tags map[string]string) {
+	saveTagsAt(GUD_DIR, tags)
+}
+
+// tagHash is the content address of a tag object, computed like commitHash.
+func tagHash(t *TagObject) string {
+	payload := *t
+	payload.ID = ""
+	data, _ := json.Marshal(&payload)
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+func verifyTagData(id string, data []byte) (*TagObject, error) {
+	var t TagObject
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("corrupt tag %s: %v", id, err)
+	}
+	if t.ID != id {
+		return nil, fmt.Errorf("tag %s has mismatched id %q", id, t.ID)
+	}
+	if tagHash(&t) != id {
+		return nil, fmt.Errorf("tag %s: hash mismatch (content hashes to %s)", id, tagHash(&t))
+	}
+	return &t, nil
+}
+
+func readTagObjectAt(gudDir, id string) (*TagObject, error) {
+	kind, data, err := readObjectAt(gudDir, id)
+	if err != nil || kind != OBJ_TAG {
+		return nil, fmt.Errorf("tag object not found: %s", id)
+	}
+	var t TagObject
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("corrupt tag %s: %v", id, err)
+	}
+	return &t, nil
+}
+
+func writeTagObject(t *TagObject) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := tagObjectsDirAt(GUD_DIR)
+	os.MkdirAll(dir, 0755)
+	return os.WriteFile(filepath.Join(dir, t.ID+".json"), data, 0644)
+}
+
+// peelAt follows tag objects to the commits they finally point at. It also
+// returns the tag objects passed on the way. IDs that are not tag objects
+// are returned unchanged.
+func peelAt(gudDir string, ids []string) ([]string, map[string]bool) {
+	var commits []string
+	tagObjects := make(map[string]bool)
+	for _, id := range ids {
+		for depth := 0; depth < 10; depth++ {
+			if kind, ok := objectKindAt(gudDir, id); !ok || kind != OBJ_TAG {
+				break
+			}
+			t, err := readTagObjectAt(gudDir, id)
+			if err != nil {
+				break
+			}
+			tagObjects[id] = true
+			id = t.Object
+		}
+		commits = append(commits, id)
+	}
+	return commits, tagObjects
+}
+
+// peelTag resolves a tag's object ID to the commit it names.
+func peelTag(id string) string {
+	commits, _ := peelAt(GUD_DIR, []string{id})
+	return commits[0]
+}
+
+// validRefName rejects names that could not be used as a ref. Branch and tag
+// names both follow it.
+func validRefName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	return !strings.ContainsAny(name, " \t~^:?*[\\") && !strings.Contains(name, "..") &&
+		!strings.Contains(name, "//") && !strings.Contains(name, "@{")
+}
+
+func validTagName(name string) bool {
+	return validRefName(name)
+}
+
+func handleTagCommand(args []string) {
+	if len(args) == 0 {
+		listTags("", "")
+		return
+	}
+	switch args[0] {
+	case "create":
+		createTagCommand(args[1:])
+	case "list", "-l", "--list":
+		pattern, sortKey := "", ""
+		for _, a := range args[1:] {
+			if strings.HasPrefix(a, "--sort=") {
+				sortKey = strings.TrimPrefix(a, "--sort=")
+			} else {
+				pattern = a
+			}
+		}
+		listTags(pattern, sortKey)
+	case "show":
+		if len(args) != 2 {
+			fmt.Println("Usage: gud tag show <name>")
+			return
+		}
+		showTag(args[1])
+	case "delete", "-d":
+		if len(args) != 2 {
+			fmt.Println("Usage: gud tag delete <name>")
+			return
+		}
+		deleteTag(args[1])
+	default:
+		fmt.Println("Unknown tag command:", args[0])
+	}
+}
+
+// createTagCommand parses "[-a|-s] [-m <msg>] [-f] <name> [<revision>]".
+func createTagCommand(args []string) {
+	annotate, force := false, false
+	sign := configBool("tag.gpgSign", false)
+	var messages, positional []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-a":
+			annotate = true
+		case args[i] == "-s" || args[i] == "--sign":
+			annotate, sign = true, true
+		case args[i] == "--no-sign":
+			sign = false
+		case args[i] == "-f" || args[i] == "--force":
+			force = true
+		case args[i] == "-m" && i+1 < len(args):
+			messages = append(messages, args[i+1])
+			annotate = true
+			i++
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		fmt.Println("Usage: gud tag create [-a|-s] [-m <message>] [-f] <name> [<revision>]")
+		return
+	}
+	message := strings.Join(messages, "\n\n")
+	if annotate && message == "" {
+		message = editMessage("\n# Enter the message for tag " + positional[0] + ". Lines starting with '#' are ignored.\n")
+		if message == "" {
+			fmt.Println("Aborting tag: empty message.")
+			return
+		}
+	}
+	tagCommit(positional[0], optionalArg(positional[1:]), annotate, sign && annotate, message, force)
+}
+
+func tagCommit(tag, rev string, annotate, sign bool, message string, force bool) {
+	if !validTagName(tag) {
+		fmt.Println("Invalid tag name:", tag)
+		return
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	commitID, err := resolveRevision(rev)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	tags := loadTags()
+	old, exists := tags[tag]
+	if exists && !force {
+		fmt.Printf("Tag '%s' already exists (use -f to replace it).\n", tag)
+		return
+	}
+
+	id := commitID
+	if annotate {
+		t := &TagObject{
+			Object:    commitID,
+			Type:      "commit",
+			Tag:       tag,
+			Tagger:    userIdentity(),
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   message,
+		}
+		if sign {
+			if err := signTag(t); err != nil {
+				fmt.Println("Error signing tag:", err)
+				return
+			}
+		}
+		t.ID = tagHash(t)
+		if err := writeTagObject(t); err != nil {
+			fmt.Println("Error writing tag:", err)
+			return
+		}
+		id = t.ID
+	}
+	tags[tag] = id
+	saveTags(tags)
+	if exists && old != id {
+		fmt.Printf("Updated tag '%s' (was %s)\n", tag, shortID(old))
+		return
+	}
+	fmt.Printf("Tagged commit %s as '%s'\n", commitID, tag)
+}
+
+// listTags prints the tags matching a glob pattern, sorted by name or, with
+// sortKey "version" (or "v:refname"), by version number. A leading "-"
+// reverses the order.
+func listTags(pattern, sortKey string) {
+	tags := loadTags()
+	var names []string
+	for name := range tags {
+		if pattern != "" {
+			if ok, _ := filepath.Match(pattern, name); !ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		fmt.Println("No tags found.")
+		return
+	}
+
+	reverse := strings.HasPrefix(sortKey, "-")
+	switch strings.TrimPrefix(sortKey, "-") {
+	case "version", "v:refname", "version:refname":
+		sort.Slice(names, func(i, j int) bool { return compareVersions(names[i], names[j]) < 0 })
+	case "", "refname":
+		sort.Strings(names)
+	default:
+		fmt.Println("Unknown sort key:", sortKey)
+		return
+	}
+	if reverse {
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+	}
+
+	fmt.Println("Tags:")
+	for _, name := range names {
+		id := tags[name]
+		if t, err := readTagObjectAt(GUD_DIR, id); err == nil {
+			subject := strings.SplitN(t.Message, "\n", 2)[0]
+			fmt.Printf(" - %s: %s  %s\n", name, shortID(peelTag(id)), subject)
+			continue
+		}
+		fmt.Printf(" - %s: %s\n", name, shortID(id))
+	}
+}
+
+// compareVersions orders names like v1.2 < v1.10 by comparing runs of
+// digits numerically and everything else as text.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		ca, cb := versionChunk(a), versionChunk(b)
+		a, b = a[len(ca):], b[len(cb):]
+		if ca == cb {
+			continue
+		}
+		da, db := ca[0] >= '0' && ca[0] <= '9', cb[0] >= '0' && cb[0] <= '9'
+		if da && db {
+			na, nb := strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+			if len(na) != len(nb) {
+				if len(na) < len(nb) {
+					return -1
+				}
+				return 1
+			}
+			if na != nb {
+				return strings.Compare(na, nb)
+			}
+			continue
+		}
+		return strings.Compare(ca, cb)
+	}
+	return len(a) - len(b)
+}
+
+func versionChunk(s string) string {
+	digit := s[0] >= '0' && s[0] <= '9'
+	i := 1
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digit {
+		i++
+	}
+	return s[:i]
+}
+
+func showTag(name string) {
+	id, ok := loadTags()[name]
+	if !ok {
+		fmt.Println("Tag not found:", name)
+		return
+	}
+	t, err := readTagObjectAt(GUD_DIR, id)
+	if err != nil {
+		fmt.Printf("tag %s (lightweight)\ncommit %s\n", name, id)
+		return
+	}
+	fmt.Printf("tag %s\nTagger: %s\nDate:   %s\n\n%s\n\ncommit %s\n", t.Tag, t.Tagger, t.Timestamp, t.Message, peelTag(id))
+}
+
+func deleteTag(tag string) {
+	tags := loadTags()
+	id, ok := tags[tag]
+	if !ok {
+		fmt.Println("Tag not found:", tag)
+		return
+	}
+	delete(tags, tag)
+	saveTags(tags)
+	fmt.Printf("Deleted tag '%s' (was %s)\n", tag, shortID(id))
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ----------------------------------------
+   Transports: exchanging refs and objects with a remote
+-------------------------------------------*/
+
+// refAdvertisement is what a repository tells a client about its refs.
+// Refs are keyed by full name, e.g. "refs/heads/main".
+type refAdvertisement struct {
+	Head string            `json:"head,omitempty"`
+	Refs map[string]string `json:"refs"`
+}
+
+// refUpdate asks a remote to move Ref to New. With Lease set the update only
+// succeeds if the ref still points to Old.
+type refUpdate struct {
+	Ref   string `json:"ref"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new"`
+	Force bool   `json:"force,omitempty"`
+	Lease bool   `json:"lease,omitempty"`
+}
+
+type refUpdateResult struct {
+	Ref   string `json:"ref"`
+	Error string `json:"error,omitempty"`
+}
+
+type transport interface {
+	listRefs() (*refAdvertisement, error)
+	// fetchObjects returns a pack of the objects reachable from wants that
+	// are not reachable from haves, limited to depth commits per want if
+	// depth > 0.
+	fetchObjects(wants, haves []string, depth int) ([]byte, error)
+	pushObjects(pack []byte, updates []refUpdate) ([]refUpdateResult, error)
+	close()
+}
+
+func openTransport(url string, forPush bool) (transport, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return newHTTPTransport(url), nil
+	}
+	if host, port, path, ok := parseSSHURL(url); ok {
+		return newSSHTransport(host, port, path, forPush)
+	}
+	gudDir, err := localRemoteGudDir(url)
+	if err != nil {
+		return nil, err
+	}
+	return &localTransport{gudDir}, nil
+}
+
+// localTransport talks to a repository on the local filesystem.
+type localTransport struct {
+	gudDir string
+}
+
+func (t *localTransport) listRefs() (*refAdvertisement, error) {
+	return advertiseRefsAt(t.gudDir), nil
+}
+
+func (t *localTransport) fetchObjects(wants, haves []string, depth int) ([]byte, error) {
+	return uploadPackAt(t.gudDir, wants, haves, depth)
+}
+
+func (t *localTransport) pushObjects(pack []byte, updates []refUpdate) ([]refUpdateResult, error) {
+	return receiveObjectsAt(t.gudDir, pack, updates)
+}
+
+func (t *localTransport) close() {}
+
+/* ----------------------------------------
+   Serving side, shared by every transport
+-------------------------------------------*/
+
+func advertiseRefsAt(gudDir string) *refAdvertisement {
+	adv := &refAdvertisement{Refs: make(map[string]string)}
+	for name, id := range loadBranchesAt(gudDir) {
+		if id != "" {
+			adv.Refs["refs/heads/"+name] = id
+		}
+	}
+	for name, id := range loadTagsAt(gudDir) {
+		adv.Refs["refs/tags/"+name] = id
+	}
+	if data, err := os.ReadFile(filepath.Join(gudDir, "HEAD")); err == nil {
+		adv.Head = strings.TrimSpace(string(data))
+	}
+	return adv
+}
+
+// collectObjectsAt lists the objects reachable from wants but not from any
+// of the haves the repository knows about. A positive depth keeps only the
+// commits at most depth-1 parents away from a want (shallow history). Tag
+// objects among the wants are sent along with the history they point at.
+func collectObjectsAt(gudDir string, wants, haves []string, depth int) ([]string, error) {
+	var known []string
+	for _, h := range haves {
+		if hasObjectAt(gudDir, h) {
+			known = append(known, h)
+		}
+	}
+	known, _ = peelAt(gudDir, known)
+	stop := reachableCommitsAt(gudDir, known, nil)
+	for _, w := range wants {
+		if !hasObjectAt(gudDir, w) {
+			return nil, fmt.Errorf("remote does not have object %s", w)
+		}
+	}
+	heads, tagObjects := peelAt(gudDir, wants)
+	ids := reachableCommitsAt(gudDir, heads, stop)
+	if depth > 0 {
+		ids = commitsWithinDepthAt(gudDir, heads, stop, depth)
+	}
+	var list []string
+	for id := range ids {
+		list = append(list, id)
+	}
+	for id := range tagObjects {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// uploadPackAt answers a fetch with a pack of the objects it needs.
+func uploadPackAt(gudDir string, wants, haves []string, depth int) ([]byte, error) {
+	ids, err := collectObjectsAt(gudDir, wants, haves, depth)
+	if err != nil {
+		return nil, err
+	}
+	return packObjectsAt(gudDir, ids)
+}
+
+// receiveObjectsAt stores a pushed pack and applies ref updates, rejecting
+// non-fast-forwards unless forced and stale leases.
+func receiveObjectsAt(gudDir string, pack []byte, updates []refUpdate) ([]refUpdateResult, error) {
+	if len(pack) > 0 {
+		if _, err := storePackAt(gudDir, pack); err != nil {
+			return nil, err
+		}
+	}
+
+	bare := filepath.Base(gudDir) != filepath.Base(GUD_DIR)
+	checkedOut := ""
+	if data, err := os.ReadFile(filepath.Join(gudDir, "HEAD")); err == nil {
+		checkedOut = strings.TrimSpace(string(data))
+	}
+
+	branches := loadBranchesAt(gudDir)
+	tags := loadTagsAt(gudDir)
+	olds := make(map[string]string)
+	for _, u := range updates {
+		if strings.HasPrefix(u.Ref, "refs/tags/") {
+			olds[u.Ref] = tags[strings.TrimPrefix(u.Ref, "refs/tags/")]
+		} else {
+			olds[u.Ref] = branches[strings.TrimPrefix(u.Ref, "refs/heads/")]
+		}
+	}
+	// Hook output goes to stderr: over ssh stdout carries the protocol.
+	var results []refUpdateResult
+	if err := runHookAt(gudDir, "pre-receive", receiveHookInput(olds, updates), os.Stderr); err != nil {
+		for _, u := range updates {
+			results = append(results, refUpdateResult{Ref: u.Ref, Error: "pre-receive hook declined"})
+		}
+		return results, nil
+	}
+	for _, u := range updates {
+		res := refUpdateResult{Ref: u.Ref}
+		if strings.HasPrefix(u.Ref, "refs/tags/") {
+			res.Error = updateTagRef(gudDir, tags, u)
+			results = append(results, res)
+			continue
+		}
+		name := strings.TrimPrefix(u.Ref, "refs/heads/")
+		current := branches[name]
+		switch {
+		case !strings.HasPrefix(u.Ref, "refs/heads/"):
+			res.Error = "unsupported ref"
+		case !validBranchName(name):
+			res.Error = "invalid branch name"
+		case u.Lease && current != u.Old:
+			res.Error = "stale info"
+		case !bare && name == checkedOut:
+			res.Error = "refusing to update checked out branch"
+		case u.New == "":
+			delete(branches, name)
+		case !hasCommitAt(gudDir, u.New):
+			res.Error = "missing object " + u.New
+		case !u.Force && current != "" && !isAncestorAt(gudDir, current, u.New):
+			res.Error = "non-fast-forward"
+		default:
+			branches[name] = u.New
+		}
+		results = append(results, res)
+	}
+	saveBranchesAt(gudDir, branches)
+	saveTagsAt(gudDir, tags)
+
+	var applied []refUpdate
+	for i, u := range updates {
+		if results[i].Error == "" {
+			applied = append(applied, u)
+			if strings.HasPrefix(u.Ref, "refs/heads/") {
+				appendReflogAt(gudDir, u.Ref, olds[u.Ref], u.New, "push")
+			}
+		}
+	}
+	if len(applied) > 0 {
+		runHookAt(gudDir, "post-receive", receiveHookInput(olds, applied), os.Stderr)
+	}
+	return results, nil
+}
+
+// updateTagRef applies a pushed tag. Tags do not move unless forced.
+func updateTagRef(gudDir string, tags map[string]string, u refUpdate) string {
+	name := strings.TrimPrefix(u.Ref, "refs/tags/")
+	current, exists := tags[name]
+	switch {
+	case !validTagName(name):
+		return "invalid tag name"
+	case u.Lease && current != u.Old:
+		return "stale info"
+	case u.New == "":
+		delete(tags, name)
+	case !hasObjectAt(gudDir, u.New):
+		return "missing object " + u.New
+	case exists && current != u.New && !u.Force:
+		return "already exists"
+	default:
+		tags[name] = u.New
+	}
+	return ""
+}
+
+/* ----------------------------------------
+   push / pull
+-------------------------------------------*/
+
+// localHaves lists every commit reachable from our branches, so a remote can
+// leave out anything we already have.
+func localHaves() []string {
+	var heads []string
+	for _, id := range loadBranches() {
+		heads = append(heads, id)
+	}
+	var haves []string
+	for id := range reachableCommitsAt(GUD_DIR, heads, nil) {
+		haves = append(haves, id)
+	}
+	sort.Strings(haves)
+	return haves
+}
+
+func pushRemote(args []string) {
+	force, lease, setUpstream, pushTags := false, false, false, false
+	verify := true
+	leaseExpect := ""
+	var positional []string
+	for _, a := range args {
+		switch {
+		case a == "--force" || a == "-f":
+			force = true
+		case a == "--set-upstream" || a == "-u":
+			setUpstream = true
+		case a == "--tags":
+			pushTags = true
+		case a == "--no-verify":
+			verify = false
+		case a == "--force-with-lease":
+			lease = true
+		case strings.HasPrefix(a, "--force-with-lease="):
+			lease = true
+			leaseExpect = strings.TrimPrefix(a, "--force-with-lease=")
+		default:
+			positional = append(positional, a)
+		}
+	}
+	if len(positional) > 2 {
+		fmt.Println("Usage: gud push [-u] [--tags] [--no-verify] [--force|--force-with-lease[=<expected>]] [remote] [branch]")
+		return
+	}
+	remote, branch := defaultRemote(), currentBranch()
+	if len(positional) > 0 {
+		remote = positional[0]
+	}
+	if len(positional) > 1 {
+		branch = positional[1]
+	}
+
+	local := loadBranches()[branch]
+	if local == "" && !pushTags {
+		fmt.Println("Branch has no commits to push:", branch)
+		return
+	}
+
+	url, err := resolveRemote(remote)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	// Without an explicit value the lease is our remote-tracking branch: the
+	// push fails if someone updated the remote since our last fetch. A remote
+	// given by URL has no tracking branch to take it from.
+	_, named := remoteURL(remote)
+	leaseOld := ""
+	if lease {
+		switch {
+		case leaseExpect != "":
+			if leaseOld, err = resolveRevision(leaseExpect); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+		case named:
+			leaseOld = loadRemoteRefs(remote)[branch]
+		default:
+			fmt.Printf("Error: %s has no remote-tracking branch to lease from; use --force-with-lease=<expected>.\n", remote)
+			return
+		}
+	}
+	t, err := openTransport(url, true)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer t.close()
+
+	adv, err := t.listRefs()
+	if err != nil {
+		fmt.Println("Error reading remote refs:", err)
+		return
+	}
+	ref := "refs/heads/" + branch
+	remoteHead := adv.Refs[ref]
+	var updates []refUpdate
+	var wants []string
+	// A branch that cannot be fast-forwarded is reported but does not stop
+	// the tags from being pushed.
+	rejected := false
+	if local != "" && remoteHead != local {
+		if !force && !lease && remoteHead != "" && !(hasCommit(remoteHead) && isAncestor(remoteHead, local)) {
+			rejected = true
+		} else {
+			updates = append(updates, refUpdate{Ref: ref, New: local, Force: force || lease})
+			wants = append(wants, local)
+		}
+	}
+	if pushTags {
+		tags := loadTags()
+		for _, name := range sortedKeys(tags) {
+			if adv.Refs["refs/tags/"+name] != tags[name] {
+				updates = append(updates, refUpdate{Ref: "refs/tags/" + name, New: tags[name], Force: force})
+				wants = append(wants, tags[name])
+			}
+		}
+	}
+	reportRejection := func() {
+		if rejected {
+			fmt.Printf(" ! [rejected] %s -> %s (non-fast-forward)\n", branch, branch)
+			fmt.Println("hint: pull the remote changes first, or use --force to overwrite them.")
+		}
+	}
+	if len(updates) == 0 {
+		if rejected {
+			reportRejection()
+		} else {
+			fmt.Println("Everything up-to-date")
+		}
+		return
+	}
+	if verify {
+		var input strings.Builder
+		for _, u := range updates {
+			fmt.Fprintf(&input, "%s %s %s %s\n", u.Ref, hookID(u.New), u.Ref, hookID(adv.Refs[u.Ref]))
+		}
+		if err := runHookAt(GUD_DIR, "pre-push", input.String(), os.Stdout, remote, url); err != nil {
+			fmt.Println("Push aborted:", err)
+			return
+		}
+	}
+
+	var haves []string
+	for _, id := range adv.Refs {
+		if hasObjectAt(GUD_DIR, id) {
+			haves = append(haves, id)
+		}
+	}
+	ids, err := collectObjectsAt(GUD_DIR, wants, haves, 0)
+	if err != nil {
+		fmt.Println("Error collecting objects:", err)
+		return
+	}
+	pack, err := packObjectsAt(GUD_DIR, ids)
+	if err != nil {
+		fmt.Println("Error packing objects:", err)
+		return
+	}
+
+	if lease && len(updates) > 0 && updates[0].Ref == ref {
+		updates[0].Lease = true
+		updates[0].Old = leaseOld
+	}
+	results, err := t.pushObjects(pack, updates)
+	if err != nil {
+		fmt.Println("Error pushing:", err)
+		return
+	}
+	fmt.Printf("To %s (%d objects)\n", url, len(ids))
+	for _, r := range results {
+		if strings.HasPrefix(r.Ref, "refs/tags/") {
+			name := strings.TrimPrefix(r.Ref, "refs/tags/")
+			switch {
+			case r.Error != "":
+				fmt.Printf(" ! [rejected] %s -> %s (%s)\n", name, name, r.Error)
+			case adv.Refs[r.Ref] == "":
+				fmt.Printf(" * [new tag]   %s -> %s\n", name, name)
+			default:
+				fmt.Printf(" + [tag update] %s -> %s\n", name, name)
+			}
+			continue
+		}
+		if r.Error != "" {
+			fmt.Printf(" ! [rejected] %s -> %s (%s)\n", branch, branch, r.Error)
+			continue
+		}
+		fmt.Printf("   %s..%s  %s -> %s\n", shortID(remoteHead), shortID(local), branch, branch)
+		if named {
+			tracking := loadRemoteRefs(remote)
+			tracking[branch] = local
+			saveRemoteRefs(remote, tracking)
+			if setUpstream {
+				if err := setBranchUpstream(branch, remote, branch); err == nil {
+					fmt.Printf("Branch '%s' set up to track '%s/%s'.\n", branch, remote, branch)
+				}
+			}
+		}
+	}
+	reportRejection()
+}
+
+func pullRemote(args []string) {
+	rebase := configBool("pull.rebase", false)
+	ffOnly := configBool("pull.ff-only", false)
+	verify := true
+	var positional []string
+	for _, a := range args {
+		switch a {
+		case "--rebase", "-r":
+			rebase = true
+		case "--no-rebase":
+			rebase = false
+		case "--ff-only":
+			ffOnly = true
+		case "--no-verify":
+			verify = false
+		default:
+			positional = append(positional, a)
+		}
+	}
+	if len(positional) > 2 {
+		fmt.Println("Usage: gud pull [--rebase|--no-rebase|--ff-only] [--no-verify] [remote] [branch]")
+		return
+	}
+	remote, branch := "", ""
+	if len(positional) > 0 {
+		remote = positional[0]
+	}
+	if len(positional) > 1 {
+		branch = positional[1]
+	}
+	if upRemote, upBranch, ok := branchUpstream(currentBranch()); ok && branch == "" && (remote == "" || remote == upRemote) {
+		remote, branch = upRemote, upBranch
+	}
+	if remote == "" {
+		remote = DEFAULT_REMOTE
+	}
+	if branch == "" {
+		branch = currentBranch()
+	}
+
+	adv, ok := fetchRemote(remote, fetchOptions{})
+	if !ok {
+		return
+	}
+	theirs := adv.Refs["refs/heads/"+branch]
+	if theirs == "" {
+		fmt.Println("Remote has no branch:", branch)
+		return
+	}
+
+	url, _ := resolveRemote(remote)
+	label := fmt.Sprintf("%s of %s", branch, url)
+	msg := fmt.Sprintf("Merge branch '%s' of %s", branch, url)
+	before := currentBranchHead()
+	if integrateCommit(theirs, label, msg, rebase, ffOnly, verify) && !rebase && currentBranchHead() != before {
+		runHook("post-merge", "0")
+	}
+}